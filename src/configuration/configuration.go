@@ -2,10 +2,12 @@ package configuration
 
 import (
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
 
+	"github.com/samber/lo"
 	"github.com/santhosh-tekuri/jsonschema/v5"
 	"gopkg.in/yaml.v3"
 )
@@ -15,7 +17,9 @@ import (
 //go:embed schema.json
 var schema []byte
 
-func Load() (Config, string, error) {
+// Load loads the configuration, deviceTypes being the device types known to
+// the device drivers
+func Load(deviceTypes []string) (Config, string, error) {
 	var configPath string
 	var config Config
 	var rawConfig map[string]interface{}
@@ -44,41 +48,138 @@ func Load() (Config, string, error) {
 	if err != nil {
 		return config, configPath, err
 	}
-	err = check(rawConfig)
+	err = check(rawConfig, deviceTypes)
 	if err != nil {
 		return config, configPath, err
 	}
 	// Real unmarshal
-	err = yaml.Unmarshal(content, &config)
-	for i, rule := range config.Rules {
-		for j, action := range rule.Actions {
-			var iface interface{}
-			if action.Type == SetDefaultOutput {
-				iface = &Target{}
-			} else {
-				iface = &TypedTarget{}
+	if err = yaml.Unmarshal(content, &config); err != nil {
+		return config, configPath, err
+	}
+	for i := range config.Rules {
+		if err := decodeTargets(config.Rules[i].Actions, config.Groups); err != nil {
+			return config, configPath, err
+		}
+		for j, zone := range config.Rules[i].Zones {
+			if zone.MinValue > zone.MaxValue {
+				return config, configPath, fmt.Errorf("rule %d zone %d: minValue %d is greater than maxValue %d", i+1, j+1, zone.MinValue, zone.MaxValue)
 			}
-			action.RawTarget.Decode(iface)
-			if err != nil {
+			if err := decodeTargets(config.Rules[i].Zones[j].Actions, config.Groups); err != nil {
 				return config, configPath, err
 			}
-			config.Rules[i].Actions[j].Target = iface
 		}
 	}
-	return config, configPath, err
+	if err := checkStrips(config); err != nil {
+		return config, configPath, err
+	}
+	return config, configPath, nil
+}
+
+// checkStrips checks that the strip targets of the actions and groups use
+// configured strips
+func checkStrips(config Config) error {
+	checkTarget := func(target TypedTarget) error {
+		if target.Strip == "" || lo.ContainsBy(config.Strips, func(strip Strip) bool {
+			return strip.Name == target.Strip
+		}) {
+			return nil
+		}
+		return fmt.Errorf("unknown strip %s", target.Strip)
+	}
+	checkActions := func(actions []Action) error {
+		for _, action := range actions {
+			if target, ok := action.Target.(*TypedTarget); ok {
+				if err := checkTarget(*target); err != nil {
+					return fmt.Errorf("line %d: %w", action.RawTarget.Line, err)
+				}
+			}
+		}
+		return nil
+	}
+	for _, rule := range config.Rules {
+		if err := checkActions(rule.Actions); err != nil {
+			return err
+		}
+		for _, zone := range rule.Zones {
+			if err := checkActions(zone.Actions); err != nil {
+				return err
+			}
+		}
+	}
+	for _, group := range config.Groups {
+		for _, target := range group.Targets {
+			if err := checkTarget(target); err != nil {
+				return fmt.Errorf("group %s: %w", group.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeTargets decodes the action targets, whose type depends on the action
+// type. Group action targets and solo groups are resolved to their group.
+func decodeTargets(actions []Action, groups []Group) error {
+	for i, action := range actions {
+		var iface interface{}
+		if action.Type == SetDefaultOutput || action.Type == SetGroupVolume {
+			iface = &Target{}
+		} else {
+			iface = &TypedTarget{}
+		}
+		if err := action.RawTarget.Decode(iface); err != nil {
+			return err
+		}
+		if action.Type == SetGroupVolume {
+			name := iface.(*Target).Name
+			group, ok := lo.Find(groups, func(group Group) bool {
+				return group.Name == name
+			})
+			if !ok {
+				return fmt.Errorf("line %d: unknown group %s", action.RawTarget.Line, name)
+			}
+			iface = &group
+		}
+		actions[i].Target = iface
+		if action.Type == Solo && action.Group != "" {
+			group, ok := lo.Find(groups, func(group Group) bool {
+				return group.Name == action.Group
+			})
+			if !ok {
+				return fmt.Errorf("line %d: unknown group %s", action.RawTarget.Line, action.Group)
+			}
+			actions[i].SoloGroup = &group
+		}
+	}
+	return nil
 }
 
-func check(configMap map[string]interface{}) error {
+// deviceSchema returns the schema with the device type enum set to deviceTypes
+func deviceSchema(deviceTypes []string) (string, error) {
+	var schemaMap map[string]interface{}
+	if err := json.Unmarshal(schema, &schemaMap); err != nil {
+		return "", err
+	}
+	deviceType := schemaMap["definitions"].(map[string]interface{})["midiDevice"].(map[string]interface{})["properties"].(map[string]interface{})["type"].(map[string]interface{})
+	deviceType["enum"] = deviceTypes
+	content, err := json.Marshal(schemaMap)
+	return string(content), err
+}
+
+func check(configMap map[string]interface{}, deviceTypes []string) error {
 	compiler := jsonschema.NewCompiler()
-	schemaReader := strings.NewReader(string(schema))
+	schema, err := deviceSchema(deviceTypes)
+	if err != nil {
+		return err
+	}
+	schemaReader := strings.NewReader(schema)
 	if err := compiler.AddResource("schema.json", schemaReader); err != nil {
 		return err
 	}
-	schema, err := compiler.Compile("schema.json")
+	compiledSchema, err := compiler.Compile("schema.json")
 	if err != nil {
 		return err
 	}
-	if err := schema.Validate(configMap); err != nil {
+	if err := compiledSchema.Validate(configMap); err != nil {
 		return err
 	}
 	return nil
@@ -1,22 +1,59 @@
 package configuration
 
-import "gopkg.in/yaml.v3"
+import (
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
 
 // MIDI Device
 
 type MidiDeviceType string
 
-const (
-	Generic          MidiDeviceType = "Generic"
-	AkaiLpd8         MidiDeviceType = "AkaiLpd8"
-	KorgNanoKontrol2 MidiDeviceType = "KorgNanoKontrol2"
-)
+// Device without driver, other types are registered by the device drivers
+const Generic MidiDeviceType = "Generic"
+
+type GestureTimings struct {
+	// Minimum press duration of a long press
+	LongPress time.Duration `yaml:"longPress"`
+	// Maximum delay between the presses of a double press
+	DoublePress time.Duration `yaml:"doublePress"`
+	// Delays before the first and between the next hold repeats
+	RepeatDelay    time.Duration `yaml:"repeatDelay"`
+	RepeatInterval time.Duration `yaml:"repeatInterval"`
+}
+
+// Program of a device with programs stored in its memory, e.g. the Akai LPD8
+type DeviceProgram struct {
+	Number  uint8         `yaml:"number"`
+	Channel uint8         `yaml:"channel"`
+	Pads    []PadProgram  `yaml:"pads"`
+	Knobs   []KnobProgram `yaml:"knobs"`
+}
+
+type PadProgram struct {
+	Note       uint8 `yaml:"note"`
+	Program    uint8 `yaml:"program"`
+	Controller uint8 `yaml:"controller"`
+	// Toggle pad, momentary otherwise
+	Toggle bool `yaml:"toggle"`
+}
+
+type KnobProgram struct {
+	Controller uint8 `yaml:"controller"`
+	MinValue   uint8 `yaml:"minValue"`
+	MaxValue   uint8 `yaml:"maxValue"`
+}
 
 type MidiDevice struct {
-	Name        string         `yaml:"name"`
-	Type        MidiDeviceType `yaml:"type"`
-	MidiInName  string         `yaml:"midiInName"`
-	MidiOutName string         `yaml:"midiOutName"`
+	Name          string         `yaml:"name"`
+	Type          MidiDeviceType `yaml:"type"`
+	MidiInName    string         `yaml:"midiInName"`
+	MidiOutName   string         `yaml:"midiOutName"`
+	MidiPortIndex int            `yaml:"midiPortIndex"`
+	Gestures      GestureTimings `yaml:"gestures"`
+	// Program written to the device at startup, nil if none
+	Program *DeviceProgram `yaml:"program"`
 }
 
 // Rule
@@ -28,26 +65,75 @@ const (
 	Note          MidiMessageType = "Note"
 	ControlChange MidiMessageType = "ControlChange"
 	ProgramChange MidiMessageType = "ProgramChange"
+	// 14-bit value, matched on its 7 most significant bits
+	PitchBend MidiMessageType = "PitchBend"
+)
+
+type Gesture string
+
+const (
+	// Any value change, the default
+	NoGesture   Gesture = ""
+	Press       Gesture = "Press"
+	Release     Gesture = "Release"
+	Tap         Gesture = "Tap"
+	LongPress   Gesture = "LongPress"
+	DoublePress Gesture = "DoublePress"
+	HoldRepeat  Gesture = "HoldRepeat"
 )
 
 type MidiMessage struct {
 	DeviceName        string          `yaml:"deviceName"`
 	DeviceControlPath string          `yaml:"deviceControlPath"`
 	Type              MidiMessageType `yaml:"type"`
-	Channel           uint8           `yaml:"channel"`
-	Note              uint8           `yaml:"note"`
-	Controller        uint8           `yaml:"controller"`
-	Program           uint8           `yaml:"program"`
+	Channel           ValueSet        `yaml:"channel"`
+	Note              ValueSet        `yaml:"note"`
+	Controller        ValueSet        `yaml:"controller"`
+	Program           ValueSet        `yaml:"program"`
 	MinValue          uint8           `yaml:"minValue"`
 	MaxValue          uint8           `yaml:"maxValue"`
+	// Relative encoder, values 1-63 turning clockwise and 65-127
+	// counterclockwise (127 being -1)
+	Relative bool `yaml:"relative"`
+	// Ticks encoding of a relative encoder, two's complement if unset
+	RelativeEncoding RelativeEncoding `yaml:"relativeEncoding"`
+	Gesture          Gesture          `yaml:"gesture"`
 }
 
+// Encoding of the ticks of a relative encoder
+type RelativeEncoding string
+
+const (
+	// 7-bit two's complement, 127 being -1, the default
+	TwosComplement RelativeEncoding = "TwosComplement"
+	// Bit 6 set when turning counterclockwise, 65 being -1, e.g. Mackie
+	// Control V-Pots
+	SignMagnitude RelativeEncoding = "SignMagnitude"
+)
+
 type PulseAudioActionType string
 
 const (
 	SetVolume        PulseAudioActionType = "SetVolume"
 	ToggleMute       PulseAudioActionType = "ToggleMute"
 	SetDefaultOutput PulseAudioActionType = "SetDefaultOutput"
+	FadeVolume       PulseAudioActionType = "FadeVolume"
+	// Scale the group targets relatively to their own volumes
+	SetGroupVolume PulseAudioActionType = "SetGroupVolume"
+	// Mute the other targets of the solo group while the target is soloed
+	Solo PulseAudioActionType = "Solo"
+	// Move the focus target selection through the streams of its type
+	FocusNext     PulseAudioActionType = "FocusNext"
+	FocusPrevious PulseAudioActionType = "FocusPrevious"
+)
+
+type FadeCurve string
+
+const (
+	LinearCurve  FadeCurve = "Linear"
+	EaseInCurve  FadeCurve = "EaseIn"
+	EaseOutCurve FadeCurve = "EaseOut"
+	SCurve       FadeCurve = "SCurve"
 )
 
 type PulseAudioTargetType string
@@ -59,29 +145,156 @@ const (
 	InputDevice    PulseAudioTargetType = "InputDevice"
 )
 
+// Targets with names select names[N], indexed targets select the Nth object
+// by creation order, N being the index of the control among the rule ones.
+// Strip targets select the stream bound to a slot of a dynamic strip, the
+// slot being N if not set. Focus targets select the stream chosen with the
+// focus actions.
 type Target struct {
-	Name string `yaml:"name"`
+	Name    string   `yaml:"name"`
+	Names   []string `yaml:"names"`
+	Indexed bool     `yaml:"indexed"`
+	Index   int      `yaml:"-"`
 }
 
 type TypedTarget struct {
-	Type PulseAudioTargetType `yaml:"type"`
-	Name string               `yaml:"name"`
+	Type    PulseAudioTargetType `yaml:"type"`
+	Name    string               `yaml:"name"`
+	Names   []string             `yaml:"names"`
+	Indexed bool                 `yaml:"indexed"`
+	Strip   string               `yaml:"strip"`
+	// Strip slot, from 1
+	Slot  int    `yaml:"slot"`
+	Focus string `yaml:"focus"`
+	Index int    `yaml:"-"`
 }
 
+// Highest volume an action can set, 1.0 being 100%
+const MaxVolumeLimit = 1.5
+
 type Action struct {
 	Type      PulseAudioActionType `yaml:"type"`
 	RawTarget yaml.Node            `yaml:"target"`
 	Target    interface{}          `yaml:"-"`
+	// Volume range the MIDI value range is mapped onto, for SetVolume and
+	// SetGroupVolume
+	MinVolume float32  `yaml:"minVolume"`
+	MaxVolume *float32 `yaml:"maxVolume"`
+	// Fade duration smoothing the volume changes, for SetVolume
+	Smoothing time.Duration `yaml:"smoothing"`
+	// Volume reached at the end of the fade, for FadeVolume
+	Volume   float32       `yaml:"volume"`
+	Duration time.Duration `yaml:"duration"`
+	Curve    FadeCurve     `yaml:"curve"`
+	// Solo group name for Solo, all playback streams if empty
+	Group     string `yaml:"group"`
+	SoloGroup *Group `yaml:"-"`
+}
+
+// VolumeRange returns the volume range of the action, the maximum volume
+// being 1.0 if it is not set
+func (action Action) VolumeRange() (float32, float32) {
+	if action.MaxVolume == nil {
+		return action.MinVolume, 1
+	}
+	return action.MinVolume, *action.MaxVolume
 }
 
+// Value range of a rule, whose actions run when the value enters it
+type Zone struct {
+	MinValue uint8    `yaml:"minValue"`
+	MaxValue uint8    `yaml:"maxValue"`
+	Actions  []Action `yaml:"actions"`
+}
+
+// How the ToggleMute actions of a rule choose the mute state of their targets
+type MutePolicy string
+
+const (
+	// Mute all the targets if any is unmuted, else unmute them all
+	MuteIfAnyUnmuted MutePolicy = "AnyUnmuted"
+	// Unmute all the targets if any is muted, else mute them all
+	UnmuteIfAnyMuted MutePolicy = "AnyMuted"
+	// Set all the targets to the opposite of the first one
+	FollowFirst MutePolicy = "First"
+	// Toggle each target on its own
+	ToggleIndependently MutePolicy = "Independent"
+)
+
 type Rule struct {
 	MidiMessage MidiMessage `yaml:"midiMessage"`
 	Actions     []Action    `yaml:"actions"`
+	Zones       []Zone      `yaml:"zones"`
+	// Distance a value must move past the current zone bounds to leave it
+	Hysteresis uint8      `yaml:"hysteresis"`
+	MutePolicy MutePolicy `yaml:"mutePolicy"`
+}
+
+// PulseAudio
+
+type PulseAudioOutagePolicy string
+
+const (
+	DropActions  PulseAudioOutagePolicy = "Drop"
+	QueueActions PulseAudioOutagePolicy = "Queue"
+)
+
+type PulseAudio struct {
+	// What to do with actions while the PulseAudio server is unreachable
+	OutagePolicy PulseAudioOutagePolicy `yaml:"outagePolicy"`
+}
+
+// Ducking
+
+type DuckingDetection string
+
+const (
+	// Trigger stream exists
+	DetectPresent DuckingDetection = "Present"
+	// Trigger stream exists and is not corked
+	DetectPlaying DuckingDetection = "Playing"
+)
+
+type Ducking struct {
+	Trigger   TypedTarget      `yaml:"trigger"`
+	Detection DuckingDetection `yaml:"detection"`
+	Targets   []TypedTarget    `yaml:"targets"`
+	// Attenuation in dB
+	Amount  float32       `yaml:"amount"`
+	Attack  time.Duration `yaml:"attack"`
+	Release time.Duration `yaml:"release"`
+	// Delay before releasing once the trigger is inactive
+	Hold time.Duration `yaml:"hold"`
+}
+
+// Dynamic strips
+
+// Pool of slots bound to the streams matching the filters as they appear
+type Strip struct {
+	Name string `yaml:"name"`
+	// PlaybackStream or RecordStream
+	Type  PulseAudioTargetType `yaml:"type"`
+	Slots int                  `yaml:"slots"`
+	// Regular expressions on stream names, all streams are included if empty
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// Groups
+
+// Targets scaled together by a group master, keeping their relative volumes
+type Group struct {
+	Name    string        `yaml:"name"`
+	Targets []TypedTarget `yaml:"targets"`
 }
 
 // Configuration
 
 type Config struct {
+	PulseAudio  PulseAudio   `yaml:"pulseAudio"`
 	MidiDevices []MidiDevice `yaml:"midiDevices"`
 	Rules       []Rule       `yaml:"rules"`
+	Ducking     []Ducking    `yaml:"ducking"`
+	Strips      []Strip      `yaml:"strips"`
+	Groups      []Group      `yaml:"groups"`
 }
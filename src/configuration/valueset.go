@@ -0,0 +1,99 @@
+package configuration
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Set of MIDI values, configured as a single value, a list, or a string of
+// comma separated values and "min-max" ranges, e.g. "1-4,7"
+type ValueSet []uint8
+
+var itemRe = regexp.MustCompile(`^\s*([0-9]+)\s*(?:-\s*([0-9]+)\s*)?$`)
+
+// parseValueSet parses comma separated values and "min-max" ranges
+func parseValueSet(value string) (ValueSet, error) {
+	set := ValueSet{}
+	for _, item := range strings.Split(value, ",") {
+		matches := itemRe.FindStringSubmatch(item)
+		if matches == nil {
+			return nil, fmt.Errorf("bad value or range %q", strings.TrimSpace(item))
+		}
+		first, err := strconv.ParseUint(matches[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad value %s", matches[1])
+		}
+		last := first
+		if matches[2] != "" {
+			if last, err = strconv.ParseUint(matches[2], 10, 8); err != nil || first > last {
+				return nil, fmt.Errorf("bad range %s", strings.TrimSpace(item))
+			}
+		}
+		for value := first; value <= last; value++ {
+			set = append(set, uint8(value))
+		}
+	}
+	return set, nil
+}
+
+// check checks that the set values are MIDI values, each of them once
+func (set ValueSet) check() error {
+	seen := map[uint8]bool{}
+	for _, value := range set {
+		if value > 0x7f {
+			return fmt.Errorf("value %d is greater than 127", value)
+		}
+		if seen[value] {
+			return fmt.Errorf("value %d is set twice", value)
+		}
+		seen[value] = true
+	}
+	return nil
+}
+
+func (set *ValueSet) UnmarshalYAML(node *yaml.Node) error {
+	var values ValueSet
+	switch {
+	case node.Kind == yaml.ScalarNode && node.Tag == "!!int":
+		var value uint8
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		values = ValueSet{value}
+	case node.Kind == yaml.ScalarNode:
+		var err error
+		if values, err = parseValueSet(node.Value); err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+	case node.Kind == yaml.SequenceNode:
+		var list []uint8
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		values = list
+	default:
+		return fmt.Errorf("line %d: expected a value, a range or a list", node.Line)
+	}
+	if err := values.check(); err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*set = values
+	return nil
+}
+
+// Values returns the set values, a unset set being 0
+func (set ValueSet) Values() []uint8 {
+	if len(set) == 0 {
+		return []uint8{0}
+	}
+	return set
+}
+
+// First returns the first value of the set, 0 if unset
+func (set ValueSet) First() uint8 {
+	return set.Values()[0]
+}
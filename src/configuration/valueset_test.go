@@ -0,0 +1,76 @@
+package configuration
+
+import (
+	"slices"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestValueSetUnmarshal(t *testing.T) {
+	tests := []struct {
+		content string
+		want    ValueSet
+		wantErr bool
+	}{
+		{content: `7`, want: ValueSet{7}},
+		{content: `0x10`, want: ValueSet{0x10}},
+		{content: `"7"`, want: ValueSet{7}},
+		{content: `48-51`, want: ValueSet{48, 49, 50, 51}},
+		{content: `" 48 - 50 "`, want: ValueSet{48, 49, 50}},
+		{content: `5-5`, want: ValueSet{5}},
+		{content: `1-4,7`, want: ValueSet{1, 2, 3, 4, 7}},
+		{content: `"9, 1-2"`, want: ValueSet{9, 1, 2}},
+		{content: `[0, 9]`, want: ValueSet{0, 9}},
+		{content: `[127]`, want: ValueSet{127}},
+		// Reversed bounds
+		{content: `4-1`, wantErr: true},
+		// Bad ranges and lists
+		{content: `1-`, wantErr: true},
+		{content: `-1`, wantErr: true},
+		{content: `1-2-3`, wantErr: true},
+		{content: `1,,2`, wantErr: true},
+		{content: `1,`, wantErr: true},
+		{content: `a-b`, wantErr: true},
+		{content: `{min: 1}`, wantErr: true},
+		// Not MIDI values
+		{content: `128`, wantErr: true},
+		{content: `256`, wantErr: true},
+		{content: `120-130`, wantErr: true},
+		{content: `250-300`, wantErr: true},
+		{content: `[1, 200]`, wantErr: true},
+		// Duplicates
+		{content: `[1, 1]`, wantErr: true},
+		{content: `1-4,3`, wantErr: true},
+		{content: `1-4,2-6`, wantErr: true},
+	}
+	for _, test := range tests {
+		var set ValueSet
+		err := yaml.Unmarshal([]byte(test.content), &set)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %v, want an error", test.content, set)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.content, err)
+		} else if !slices.Equal(set, test.want) {
+			t.Errorf("%s: got %v, want %v", test.content, set, test.want)
+		}
+	}
+}
+
+func TestValueSetValues(t *testing.T) {
+	var unset ValueSet
+	if got := unset.Values(); !slices.Equal(got, []uint8{0}) {
+		t.Errorf("unset Values() = %v, want [0]", got)
+	}
+	if got := unset.First(); got != 0 {
+		t.Errorf("unset First() = %d, want 0", got)
+	}
+	set := ValueSet{5, 6}
+	if got := set.First(); got != 5 {
+		t.Errorf("First() = %d, want 5", got)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"regexp"
+	"slices"
 	"strconv"
 
 	"github.com/fluciotto/pamixermidicontrol/src/configuration"
@@ -16,9 +17,36 @@ import (
 	"gitlab.com/gomidi/midi/v2/sysex"
 )
 
+const deviceType configuration.MidiDeviceType = "AkaiLpd8"
+
+func init() {
+	device.Register(deviceType, func(name string) device.Driver {
+		return New(name)
+	})
+}
+
+const (
+	programs = 4
+	pads     = 8
+	knobs    = 8
+	// Program data: MIDI channel, then the pads and the knobs
+	firstPadIndex = 1
+	// Pad data: note, program, controller and toggle mode
+	padSize        = 4
+	firstKnobIndex = firstPadIndex + pads*padSize
+	// Knob data: controller, min and max values
+	knobSize    = 3
+	programSize = firstKnobIndex + knobs*knobSize
+)
+
 type AkaiLpd8 struct {
 	log        zerolog.Logger
 	DeviceName string
+	// Active program captured at startup, restored on shutdown
+	startupProgram byte
+	// Active program and its data, updated on program changes
+	activeProgram byte
+	programData   []byte
 }
 
 func New(name string) *AkaiLpd8 {
@@ -71,6 +99,30 @@ func (d *AkaiLpd8) activeProgramRequestMessage() *device.SysExMessage {
 	return device.NewSysExMessage(request, responseHandler)
 }
 
+func (d *AkaiLpd8) setActiveProgramMessage(programNumber byte) *device.SysExMessage {
+	request := []byte{
+		0xf0,
+		0x47,       // Akai
+		0x7f, 0x75, // LPD8
+		0x62, 0x00, 0x01, programNumber, // Set active program
+		0xf7,
+	}
+	// No response
+	return device.NewSysExMessage(request, nil)
+}
+
+func (d *AkaiLpd8) programMessage(programNumber byte, programData []byte) *device.SysExMessage {
+	request := append([]byte{
+		0xf0,
+		0x47,       // Akai
+		0x7f, 0x75, // LPD8
+		0x61, 0x00, 0x3a, programNumber, // Send program
+	}, programData...)
+	request = append(request, 0xf7)
+	// No response
+	return device.NewSysExMessage(request, nil)
+}
+
 func (d *AkaiLpd8) programRequestMessage(programNumber byte) *device.SysExMessage {
 	request := []byte{
 		0xf0,
@@ -81,7 +133,7 @@ func (d *AkaiLpd8) programRequestMessage(programNumber byte) *device.SysExMessag
 	}
 	responseHandler := func(bytes []byte) (rawData []byte, processedData []byte, err error) {
 		log := d.log.With().Str("SysEx", "programRequestMessage").Logger()
-		if len(bytes) != 66-2 {
+		if len(bytes) != 7+programSize {
 			log.Error().Msgf("Scene dump request response has a bad length: %d", len(bytes))
 			return []byte{}, []byte{}, fmt.Errorf("scene dump request response has a bad length: %d", len(bytes))
 		}
@@ -92,78 +144,205 @@ func (d *AkaiLpd8) programRequestMessage(programNumber byte) *device.SysExMessag
 	return device.NewSysExMessage(request, responseHandler)
 }
 
-func (d *AkaiLpd8) UpdateRules(
-	rules []configuration.Rule,
-	c chan []byte,
-	out drivers.Out,
-) (updatedRules []configuration.Rule) {
-	// Fetch program data from device
+var (
+	programRe = regexp.MustCompile("^Program([1-4])/(.*)$")
+	padRe     = regexp.MustCompile("^Pad([1-8])/(Note|ControlChange|ProgramChange)$")
+	knobRe    = regexp.MustCompile("^Knob([1-8])$")
+)
+
+func (d *AkaiLpd8) ControlPaths() []string {
+	return []string{
+		"Pad[1-8]/[Note|ControlChange|ProgramChange]",
+		"Knob[1-8]",
+		"Program[1-4]/<control path>",
+	}
+}
+
+// Identify fetches the active program, which the control paths are resolved
+// from
+func (d *AkaiLpd8) Identify(c chan []byte, out drivers.Out) error {
+	if out == nil {
+		return device.ErrNoOut
+	}
+	activeProgram, programData, err := d.readActiveProgram(c, out)
+	if err != nil {
+		return err
+	}
+	d.startupProgram = activeProgram
+	d.activeProgram = activeProgram
+	d.programData = programData
+	return nil
+}
+
+// readActiveProgram returns the active program number and data
+func (d *AkaiLpd8) readActiveProgram(c chan []byte, out drivers.Out) (byte, []byte, error) {
 	_, activeProgram, err := d.activeProgramRequestMessage().Send(c, out, d.log)
 	if err != nil {
-		panic(err)
+		return 0, nil, fmt.Errorf("could not fetch active program: %w", err)
 	}
 	d.log.Debug().Msgf("Active program % X", activeProgram)
-
 	_, programData, err := d.programRequestMessage(activeProgram[0]).Send(c, out, d.log)
 	if err != nil {
-		panic(err)
+		return 0, nil, fmt.Errorf("could not fetch program %d: %w", activeProgram[0], err)
 	}
 	d.log.Debug().Msgf("Program % X", programData)
+	return activeProgram[0], programData, nil
+}
 
-	// Get global MIDI channel from program data
-	globalMidiChannel := programData[0]
-	// Update rules with scene data
-	for _, rule := range rules {
-		if rule.MidiMessage.DeviceName != d.DeviceName {
-			continue
+// SetupChanged tells whether another program was selected with the PROG
+// button, or the active program was edited
+func (d *AkaiLpd8) SetupChanged(c chan []byte, out drivers.Out) (bool, error) {
+	activeProgram, programData, err := d.readActiveProgram(c, out)
+	if err != nil {
+		return false, err
+	}
+	if activeProgram == d.activeProgram && slices.Equal(programData, d.programData) {
+		return false, nil
+	}
+	d.log.Info().Msgf("Active program %d", activeProgram)
+	d.activeProgram = activeProgram
+	d.programData = programData
+	return true, nil
+}
+
+// ReadScene returns the active program data
+func (d *AkaiLpd8) ReadScene(c chan []byte, out drivers.Out) ([]byte, error) {
+	_, programData, err := d.programRequestMessage(d.activeProgram).Send(c, out, d.log)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch program %d: %w", d.activeProgram, err)
+	}
+	return programData, nil
+}
+
+func (d *AkaiLpd8) Programs() int {
+	return programs
+}
+
+func (d *AkaiLpd8) ReadProgram(c chan []byte, out drivers.Out, number uint8) (configuration.DeviceProgram, error) {
+	_, programData, err := d.programRequestMessage(number).Send(c, out, d.log)
+	if err != nil {
+		return configuration.DeviceProgram{}, fmt.Errorf("could not fetch program %d: %w", number, err)
+	}
+	program := configuration.DeviceProgram{
+		Number:  number,
+		Channel: programData[0],
+	}
+	for pad := 0; pad < pads; pad++ {
+		padIndex := firstPadIndex + pad*padSize
+		program.Pads = append(program.Pads, configuration.PadProgram{
+			Note:       programData[padIndex],
+			Program:    programData[padIndex+1],
+			Controller: programData[padIndex+2],
+			Toggle:     programData[padIndex+3] != 0,
+		})
+	}
+	for knob := 0; knob < knobs; knob++ {
+		knobIndex := firstKnobIndex + knob*knobSize
+		program.Knobs = append(program.Knobs, configuration.KnobProgram{
+			Controller: programData[knobIndex],
+			MinValue:   programData[knobIndex+1],
+			MaxValue:   programData[knobIndex+2],
+		})
+	}
+	return program, nil
+}
+
+func (d *AkaiLpd8) WriteProgram(c chan []byte, out drivers.Out, program configuration.DeviceProgram) error {
+	if program.Number < 1 || program.Number > programs || len(program.Pads) != pads || len(program.Knobs) != knobs {
+		return fmt.Errorf("program %d must be 1-%d with %d pads and %d knobs", program.Number, programs, pads, knobs)
+	}
+	if program.Channel > 0xf {
+		return fmt.Errorf("program %d: channel %d is not 0-15", program.Number, program.Channel)
+	}
+	for i, pad := range program.Pads {
+		if pad.Note > 0x7f || pad.Program > 0x7f || pad.Controller > 0x7f {
+			return fmt.Errorf("program %d pad %d: note, program and controller must be 0-127", program.Number, i+1)
+		}
+	}
+	for i, knob := range program.Knobs {
+		if knob.Controller > 0x7f || knob.MinValue > 0x7f || knob.MaxValue > 0x7f {
+			return fmt.Errorf("program %d knob %d: controller, minValue and maxValue must be 0-127", program.Number, i+1)
 		}
-		if rule.MidiMessage.DeviceControlPath != "" {
-			//
-			padRe := regexp.MustCompile("^Pad([1-8])/.*$")
-			padNoteRe := regexp.MustCompile("^Pad([1-8])/Note$")
-			padControlChangeRe := regexp.MustCompile("^Pad([1-8])/ControlChange$")
-			padProgramChangeRe := regexp.MustCompile("^Pad([1-8])/ProgramChange$")
-			knobRe := regexp.MustCompile("^Knob([1-8])$")
-
-			//
-			if padRe.MatchString(rule.MidiMessage.DeviceControlPath) {
-				matches := padRe.FindStringSubmatch(rule.MidiMessage.DeviceControlPath)
-				padNumber64, _ := strconv.ParseUint(matches[1], 10, 8)
-				padNumber := uint8(padNumber64)
-				padIndex := 1 + (padNumber-1)*4
-				// Update rule
-				rule.MidiMessage.Channel = globalMidiChannel
-				if padNoteRe.MatchString(rule.MidiMessage.DeviceControlPath) {
-					rule.MidiMessage.Type = configuration.Note
-				} else if padControlChangeRe.MatchString(rule.MidiMessage.DeviceControlPath) {
-					rule.MidiMessage.Type = configuration.ControlChange
-				} else if padProgramChangeRe.MatchString(rule.MidiMessage.DeviceControlPath) {
-					rule.MidiMessage.Type = configuration.ProgramChange
-				}
-				rule.MidiMessage.Note = programData[padIndex]
-				rule.MidiMessage.Controller = programData[padIndex+2]
-				rule.MidiMessage.Program = programData[padIndex+1]
-				rule.MidiMessage.MinValue = 0x0
-				rule.MidiMessage.MaxValue = 0x7f
-				updatedRules = append(updatedRules, rule)
-			} else if knobRe.MatchString(rule.MidiMessage.DeviceControlPath) {
-				matches := knobRe.FindStringSubmatch(rule.MidiMessage.DeviceControlPath)
-				knobNumber64, _ := strconv.ParseUint(matches[1], 10, 8)
-				knobNumber := uint8(knobNumber64)
-				knobIndex := 33 + (knobNumber-1)*3
-				// Update rule
-				rule.MidiMessage.Channel = globalMidiChannel
-				rule.MidiMessage.Type = configuration.ControlChange
-				rule.MidiMessage.Controller = programData[knobIndex]
-				rule.MidiMessage.MinValue = programData[knobIndex+1]
-				rule.MidiMessage.MaxValue = programData[knobIndex+2]
-				updatedRules = append(updatedRules, rule)
-			} else {
-				log.Warn().Msgf("Unknown device control path %s", rule.MidiMessage.DeviceControlPath)
-			}
-		} else {
-			updatedRules = append(updatedRules, rule)
+	}
+	programData := []byte{program.Channel}
+	for _, pad := range program.Pads {
+		toggle := byte(0)
+		if pad.Toggle {
+			toggle = 1
+		}
+		programData = append(programData, pad.Note, pad.Program, pad.Controller, toggle)
+	}
+	for _, knob := range program.Knobs {
+		programData = append(programData, knob.Controller, knob.MinValue, knob.MaxValue)
+	}
+	if _, _, err := d.programMessage(program.Number, programData).Send(c, out, d.log); err != nil {
+		return fmt.Errorf("could not send program %d: %w", program.Number, err)
+	}
+	return nil
+}
+
+func (d *AkaiLpd8) SelectProgram(c chan []byte, out drivers.Out, number uint8) error {
+	if _, _, err := d.setActiveProgramMessage(number).Send(c, out, d.log); err != nil {
+		return fmt.Errorf("could not select program %d: %w", number, err)
+	}
+	return nil
+}
+
+func (d *AkaiLpd8) Resolve(path string) (configuration.MidiMessage, error) {
+	if d.programData == nil {
+		return configuration.MidiMessage{}, fmt.Errorf("program not fetched")
+	}
+	// Control of a program, resolved while the program is active
+	if matches := programRe.FindStringSubmatch(path); matches != nil {
+		programNumber, _ := strconv.Atoi(matches[1])
+		if byte(programNumber) != d.activeProgram {
+			return configuration.MidiMessage{}, fmt.Errorf("program %d is not active: %w", programNumber, device.ErrInactive)
 		}
+		path = matches[2]
+	}
+	// Get global MIDI channel from program data
+	globalMidiChannel := d.programData[0]
+	message := configuration.MidiMessage{
+		Channel: configuration.ValueSet{globalMidiChannel},
+	}
+	if matches := padRe.FindStringSubmatch(path); matches != nil {
+		padNumber, _ := strconv.Atoi(matches[1])
+		padIndex := firstPadIndex + (padNumber-1)*padSize
+		message.Type = configuration.MidiMessageType(matches[2])
+		message.Note = configuration.ValueSet{d.programData[padIndex]}
+		message.Controller = configuration.ValueSet{d.programData[padIndex+2]}
+		message.Program = configuration.ValueSet{d.programData[padIndex+1]}
+		message.MinValue = 0x0
+		message.MaxValue = 0x7f
+		return message, nil
+	}
+	if matches := knobRe.FindStringSubmatch(path); matches != nil {
+		knobNumber, _ := strconv.Atoi(matches[1])
+		knobIndex := firstKnobIndex + (knobNumber-1)*knobSize
+		message.Type = configuration.ControlChange
+		message.Controller = configuration.ValueSet{d.programData[knobIndex]}
+		message.MinValue = d.programData[knobIndex+1]
+		message.MaxValue = d.programData[knobIndex+2]
+		return message, nil
+	}
+	return message, fmt.Errorf("no such %s control", deviceType)
+}
+
+// RestoreState sets back the program active at startup if it was changed.
+func (d *AkaiLpd8) RestoreState(c chan []byte, out drivers.Out) error {
+	if d.startupProgram == 0 {
+		return nil
+	}
+	_, activeProgram, err := d.activeProgramRequestMessage().Send(c, out, d.log)
+	if err != nil {
+		return fmt.Errorf("could not fetch active program: %w", err)
+	}
+	if activeProgram[0] == d.startupProgram {
+		return nil
+	}
+	d.log.Info().Msgf("Restoring active program %d", d.startupProgram)
+	if _, _, err := d.setActiveProgramMessage(d.startupProgram).Send(c, out, d.log); err != nil {
+		return fmt.Errorf("could not restore active program: %w", err)
 	}
-	return updatedRules
+	return nil
 }
@@ -0,0 +1,148 @@
+package akaiLpd8
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+)
+
+// fakeOut records the messages sent to the device and answers the program
+// requests with programResponse, sent without F0 and F7 as the MIDI client
+// does
+type fakeOut struct {
+	sent            [][]byte
+	programResponse []byte
+	c               chan []byte
+}
+
+func (out *fakeOut) Open() error     { return nil }
+func (out *fakeOut) Close() error    { return nil }
+func (out *fakeOut) IsOpen() bool    { return true }
+func (out *fakeOut) Number() int     { return 0 }
+func (out *fakeOut) String() string  { return "fake" }
+func (out *fakeOut) Underlying() any { return nil }
+
+func (out *fakeOut) Send(data []byte) error {
+	out.sent = append(out.sent, slices.Clone(data))
+	if len(data) > 4 && data[4] == 0x63 {
+		if out.programResponse == nil {
+			return fmt.Errorf("no program")
+		}
+		out.c <- out.programResponse
+	}
+	return nil
+}
+
+func newFakeOut(programResponse []byte) *fakeOut {
+	return &fakeOut{programResponse: programResponse, c: make(chan []byte, 1)}
+}
+
+// Program 2 dump: channel 10, pads with their note, program change,
+// controller and toggle mode, then knobs with their controller, min and max
+// values
+var programDump = []byte{
+	0x47, 0x7f, 0x75, 0x63, 0x00, 0x3a, 0x02,
+	0x09,
+	0x24, 0x00, 0x01, 0x00,
+	0x25, 0x01, 0x02, 0x00,
+	0x26, 0x02, 0x03, 0x00,
+	0x27, 0x03, 0x04, 0x00,
+	0x28, 0x04, 0x05, 0x01,
+	0x29, 0x05, 0x06, 0x01,
+	0x2a, 0x06, 0x07, 0x00,
+	0x2b, 0x07, 0x08, 0x00,
+	0x01, 0x00, 0x7f,
+	0x02, 0x00, 0x7f,
+	0x03, 0x00, 0x7f,
+	0x04, 0x00, 0x7f,
+	0x05, 0x10, 0x60,
+	0x06, 0x00, 0x7f,
+	0x07, 0x00, 0x7f,
+	0x46, 0x7f, 0x00,
+}
+
+func dumpProgram() configuration.DeviceProgram {
+	program := configuration.DeviceProgram{Number: 2, Channel: 9}
+	for pad := range uint8(pads) {
+		program.Pads = append(program.Pads, configuration.PadProgram{
+			Note:       0x24 + pad,
+			Program:    pad,
+			Controller: pad + 1,
+			Toggle:     pad == 4 || pad == 5,
+		})
+	}
+	for knob := range uint8(knobs) {
+		program.Knobs = append(program.Knobs, configuration.KnobProgram{Controller: knob + 1, MinValue: 0x00, MaxValue: 0x7f})
+	}
+	program.Knobs[4].MinValue, program.Knobs[4].MaxValue = 0x10, 0x60
+	program.Knobs[7] = configuration.KnobProgram{Controller: 0x46, MinValue: 0x7f, MaxValue: 0x00}
+	return program
+}
+
+func TestReadProgram(t *testing.T) {
+	out := newFakeOut(programDump)
+	program, err := New("test").ReadProgram(out.c, out, 2)
+	if err != nil {
+		t.Fatalf("ReadProgram failed: %s", err)
+	}
+	if want := []byte{0xf0, 0x47, 0x7f, 0x75, 0x63, 0x00, 0x01, 0x02, 0xf7}; !slices.Equal(out.sent[0], want) {
+		t.Errorf("request % X, want % X", out.sent[0], want)
+	}
+	if want := dumpProgram(); !reflect.DeepEqual(program, want) {
+		t.Errorf("ReadProgram() = %+v, want %+v", program, want)
+	}
+}
+
+func TestReadProgramBadDump(t *testing.T) {
+	out := newFakeOut(programDump[:len(programDump)-1])
+	if program, err := New("test").ReadProgram(out.c, out, 2); err == nil {
+		t.Errorf("ReadProgram() = %+v for a short dump, want an error", program)
+	}
+}
+
+func TestWriteProgram(t *testing.T) {
+	out := newFakeOut(nil)
+	if err := New("test").WriteProgram(out.c, out, dumpProgram()); err != nil {
+		t.Fatalf("WriteProgram failed: %s", err)
+	}
+	// The dump with the send program command
+	want := slices.Concat([]byte{0xf0, 0x47, 0x7f, 0x75, 0x61}, programDump[4:], []byte{0xf7})
+	if len(out.sent) != 1 || !slices.Equal(out.sent[0], want) {
+		t.Errorf("sent % X, want % X", out.sent, want)
+	}
+}
+
+func TestWriteProgramRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(program *configuration.DeviceProgram)
+	}{
+		{name: "program 0", change: func(program *configuration.DeviceProgram) { program.Number = 0 }},
+		{name: "program 5", change: func(program *configuration.DeviceProgram) { program.Number = 5 }},
+		{name: "7 pads", change: func(program *configuration.DeviceProgram) { program.Pads = program.Pads[:7] }},
+		{name: "9 knobs", change: func(program *configuration.DeviceProgram) {
+			program.Knobs = append(program.Knobs, configuration.KnobProgram{})
+		}},
+		{name: "channel 16", change: func(program *configuration.DeviceProgram) { program.Channel = 16 }},
+		{name: "pad note", change: func(program *configuration.DeviceProgram) { program.Pads[0].Note = 0x80 }},
+		{name: "pad program", change: func(program *configuration.DeviceProgram) { program.Pads[3].Program = 0xff }},
+		{name: "pad controller", change: func(program *configuration.DeviceProgram) { program.Pads[7].Controller = 0x80 }},
+		{name: "knob controller", change: func(program *configuration.DeviceProgram) { program.Knobs[0].Controller = 0x80 }},
+		{name: "knob min value", change: func(program *configuration.DeviceProgram) { program.Knobs[2].MinValue = 0x90 }},
+		{name: "knob max value", change: func(program *configuration.DeviceProgram) { program.Knobs[7].MaxValue = 0xff }},
+	}
+	for _, test := range tests {
+		program := dumpProgram()
+		test.change(&program)
+		out := newFakeOut(nil)
+		if err := New("test").WriteProgram(out.c, out, program); err == nil {
+			t.Errorf("%s: WriteProgram succeeded, want an error", test.name)
+		}
+		if len(out.sent) != 0 {
+			t.Errorf("%s: sent % X", test.name, out.sent)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// LPD8 mk2 SysEx, which differs from the original LPD8 one
+
+package akaiLpd8Mk2
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/fluciotto/pamixermidicontrol/src/device/akai"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+const deviceType configuration.MidiDeviceType = "AkaiLpd8Mk2"
+
+func init() {
+	device.Register(deviceType, func(name string) device.Driver {
+		return New(name)
+	})
+}
+
+const (
+	product = 0x4c
+	// Commands
+	sendProgram      = 0x01
+	getProgram       = 0x03
+	getActiveProgram = 0x04
+	setPadColors     = 0x06
+	programs         = 8
+	pads             = 8
+	knobs            = 8
+	// Program data: program number, MIDI channel, pressure settings, then
+	// the pads and the knobs
+	channelIndex  = 1
+	firstPadIndex = 4
+	// Pad data: note, controller, program, channel, toggle, off color and on
+	// color, colors being red, green and blue 7-bit pairs
+	padSize        = 17
+	firstKnobIndex = firstPadIndex + pads*padSize
+	// Knob data: controller, min and max values
+	knobSize    = 3
+	programSize = firstKnobIndex + knobs*knobSize
+)
+
+type color struct {
+	red, green, blue uint8
+}
+
+// Pad colors showing the feedback values
+var (
+	offColor = color{red: 0x00, green: 0xff, blue: 0x00}
+	onColor  = color{red: 0xff, green: 0x00, blue: 0x00}
+)
+
+var (
+	padRe  = regexp.MustCompile("^Pad([1-8])/(Note|ControlChange|ProgramChange)$")
+	knobRe = regexp.MustCompile("^Knob([1-8])$")
+)
+
+type AkaiLpd8Mk2 struct {
+	log        zerolog.Logger
+	DeviceName string
+	// Active program captured at startup
+	activeProgram byte
+	programData   []byte
+	// Pad colors last sent, guarded by mutex
+	mutex     sync.Mutex
+	padColors [pads]color
+	colored   bool
+}
+
+func New(name string) *AkaiLpd8Mk2 {
+	return &AkaiLpd8Mk2{
+		log:        log.With().Str("device", "Akai LPD8 mk2").Logger(),
+		DeviceName: name,
+	}
+}
+
+func (d *AkaiLpd8Mk2) activeProgramRequestMessage() *device.SysExMessage {
+	request := akai.Request(product, getActiveProgram)
+	responseHandler := func(bytes []byte) (rawData []byte, processedData []byte, err error) {
+		if len(bytes) != 7 || bytes[3] != getActiveProgram {
+			return []byte{}, []byte{}, fmt.Errorf("active program response is not an active program: % X", bytes)
+		}
+		return bytes, []byte{bytes[6]}, nil
+	}
+	return device.NewSysExMessage(request, responseHandler)
+}
+
+func (d *AkaiLpd8Mk2) programRequestMessage(programNumber byte) *device.SysExMessage {
+	request := akai.Request(product, getProgram, programNumber)
+	responseHandler := func(bytes []byte) (rawData []byte, processedData []byte, err error) {
+		if len(bytes) != 6+programSize || bytes[3] != getProgram {
+			return []byte{}, []byte{}, fmt.Errorf("program response has a bad length: %d", len(bytes))
+		}
+		return bytes, bytes[6:], nil
+	}
+	return device.NewSysExMessage(request, responseHandler)
+}
+
+func (d *AkaiLpd8Mk2) programMessage(programData []byte) *device.SysExMessage {
+	// No response
+	return device.NewSysExMessage(akai.Request(product, sendProgram, programData...), nil)
+}
+
+func (d *AkaiLpd8Mk2) padColorsMessage(colors [pads]color) *device.SysExMessage {
+	var data []byte
+	for _, color := range colors {
+		for _, component := range []uint8{color.red, color.green, color.blue} {
+			data = append(data, component>>7, component&0x7f)
+		}
+	}
+	// No response
+	return device.NewSysExMessage(akai.Request(product, setPadColors, data...), nil)
+}
+
+func (d *AkaiLpd8Mk2) ControlPaths() []string {
+	return []string{
+		"Pad[1-8]/[Note|ControlChange|ProgramChange]",
+		"Knob[1-8]",
+	}
+}
+
+// Identify fetches the active program, which the control paths are resolved
+// from, program 1 if the device does not tell its active program
+func (d *AkaiLpd8Mk2) Identify(c chan []byte, out drivers.Out) error {
+	if out == nil {
+		return device.ErrNoOut
+	}
+	_, activeProgram, err := d.activeProgramRequestMessage().Send(c, out, d.log)
+	if err != nil {
+		d.log.Warn().Msgf("Could not fetch active program, using program 1: %s", err)
+		activeProgram = []byte{1}
+	}
+	d.activeProgram = activeProgram[0]
+	d.log.Info().Msgf("Active program %d", d.activeProgram)
+	programData, err := d.ReadScene(c, out)
+	if err != nil {
+		return err
+	}
+	d.log.Debug().Msgf("Program % X", programData)
+	d.programData = programData
+	for pad := range d.padColors {
+		d.padColors[pad] = offColor
+	}
+	return nil
+}
+
+// ReadScene returns the active program data
+func (d *AkaiLpd8Mk2) ReadScene(c chan []byte, out drivers.Out) ([]byte, error) {
+	_, programData, err := d.programRequestMessage(d.activeProgram).Send(c, out, d.log)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch program %d: %w", d.activeProgram, err)
+	}
+	return programData, nil
+}
+
+// WriteScene sends program data, its first byte being the program number
+func (d *AkaiLpd8Mk2) WriteScene(c chan []byte, out drivers.Out, programData []byte) error {
+	if len(programData) != programSize || programData[0] < 1 || programData[0] > programs {
+		return fmt.Errorf("program data has a bad length %d or program number", len(programData))
+	}
+	if _, _, err := d.programMessage(programData).Send(c, out, d.log); err != nil {
+		return fmt.Errorf("could not send program %d: %w", programData[0], err)
+	}
+	return nil
+}
+
+func (d *AkaiLpd8Mk2) Resolve(path string) (configuration.MidiMessage, error) {
+	if d.programData == nil {
+		return configuration.MidiMessage{}, fmt.Errorf("program not fetched")
+	}
+	message := configuration.MidiMessage{
+		Channel: configuration.ValueSet{d.programData[channelIndex]},
+	}
+	if matches := padRe.FindStringSubmatch(path); matches != nil {
+		padNumber, _ := strconv.Atoi(matches[1])
+		padIndex := firstPadIndex + (padNumber-1)*padSize
+		message.Type = configuration.MidiMessageType(matches[2])
+		message.Note = configuration.ValueSet{d.programData[padIndex]}
+		message.Controller = configuration.ValueSet{d.programData[padIndex+1]}
+		message.Program = configuration.ValueSet{d.programData[padIndex+2]}
+		message.Channel = configuration.ValueSet{d.programData[padIndex+3]}
+		message.MinValue = 0x0
+		message.MaxValue = 0x7f
+		return message, nil
+	}
+	if matches := knobRe.FindStringSubmatch(path); matches != nil {
+		knobNumber, _ := strconv.Atoi(matches[1])
+		knobIndex := firstKnobIndex + (knobNumber-1)*knobSize
+		message.Type = configuration.ControlChange
+		message.Controller = configuration.ValueSet{d.programData[knobIndex]}
+		message.MinValue = d.programData[knobIndex+1]
+		message.MaxValue = d.programData[knobIndex+2]
+		return message, nil
+	}
+	return message, fmt.Errorf("no such %s control", deviceType)
+}
+
+// Feedback colors a pad red when value is on, e.g. when its target is muted,
+// green otherwise
+func (d *AkaiLpd8Mk2) Feedback(out drivers.Out, path string, index int, value uint8) error {
+	matches := padRe.FindStringSubmatch(path)
+	if matches == nil {
+		return nil
+	}
+	padNumber, _ := strconv.Atoi(matches[1])
+	d.mutex.Lock()
+	if value > 0 {
+		d.padColors[padNumber-1] = onColor
+	} else {
+		d.padColors[padNumber-1] = offColor
+	}
+	colors := d.padColors
+	d.colored = true
+	d.mutex.Unlock()
+	// The colors are sent all together, no SysEx response expected
+	_, _, err := d.padColorsMessage(colors).Send(nil, out, d.log)
+	return err
+}
+
+// RestoreState sends back the program captured at startup if it was changed
+// or if the pads were colored, which restores their program colors
+func (d *AkaiLpd8Mk2) RestoreState(c chan []byte, out drivers.Out) error {
+	if d.programData == nil {
+		return nil
+	}
+	programData, err := d.ReadScene(c, out)
+	if err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	colored := d.colored
+	d.mutex.Unlock()
+	if slices.Equal(programData, d.programData) && !colored {
+		return nil
+	}
+	d.log.Info().Msgf("Restoring program %d", d.activeProgram)
+	return d.WriteScene(c, out, d.programData)
+}
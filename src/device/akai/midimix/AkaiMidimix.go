@@ -0,0 +1,182 @@
+// MIDImix preset SysEx, as used by the MIDImix editor
+
+package akaiMidimix
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/fluciotto/pamixermidicontrol/src/device/akai"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+const deviceType configuration.MidiDeviceType = "AkaiMidimix"
+
+func init() {
+	device.Register(deviceType, func(name string) device.Driver {
+		return New(name)
+	})
+}
+
+const (
+	product = 0x31
+	// Preset request command
+	getPreset = 0x66
+	strips    = 8
+	// Preset data: for each strip its knobs, fader and buttons, then the
+	// master fader, each control being a channel and a controller or note
+	// number
+	controlSize = 2
+	// Knobs, fader, mute, solo and rec arm
+	stripSize   = 7 * controlSize
+	masterIndex = strips * stripSize
+	presetSize  = masterIndex + controlSize
+)
+
+// Strip controls, in preset data order
+var stripControls = []string{"Knob1", "Knob2", "Knob3", "Fader", "Mute", "Solo", "RecArm"}
+
+// Factory preset, used when the device does not send its preset
+var factoryPreset = func() []byte {
+	var preset []byte
+	for strip := 0; strip < strips; strip++ {
+		// Strips 1-4 use controllers 16-31, strips 5-8 controllers 46-61
+		controller := byte(16 + strip*4)
+		if strip >= 4 {
+			controller = byte(46 + (strip-4)*4)
+		}
+		note := byte(1 + strip*3)
+		preset = append(preset,
+			0, controller,
+			0, controller+1,
+			0, controller+2,
+			0, controller+3,
+			0, note,
+			0, note+1,
+			0, note+2,
+		)
+	}
+	return append(preset, 0, 62)
+}()
+
+var (
+	stripRe  = regexp.MustCompile("^Strip([1-8])/(Knob[1-3]|Fader|Mute|Solo|RecArm)$")
+	masterRe = regexp.MustCompile("^Master/Fader$")
+)
+
+type AkaiMidimix struct {
+	log        zerolog.Logger
+	DeviceName string
+	presetData []byte
+}
+
+func New(name string) *AkaiMidimix {
+	return &AkaiMidimix{
+		log:        log.With().Str("device", "Akai MIDImix").Logger(),
+		DeviceName: name,
+	}
+}
+
+func (d *AkaiMidimix) presetRequestMessage() *device.SysExMessage {
+	request := akai.Request(product, getPreset)
+	responseHandler := func(bytes []byte) (rawData []byte, processedData []byte, err error) {
+		if len(bytes) != 6+presetSize || bytes[3] != getPreset {
+			return []byte{}, []byte{}, fmt.Errorf("preset response has a bad length: %d", len(bytes))
+		}
+		return bytes, bytes[6:], nil
+	}
+	return device.NewSysExMessage(request, responseHandler)
+}
+
+func (d *AkaiMidimix) ControlPaths() []string {
+	return []string{
+		"Strip[1-8]/[Knob[1-3]|Fader|Mute|Solo|RecArm]",
+		"Master/Fader",
+	}
+}
+
+// Identify fetches the preset, which the control paths are resolved from, the
+// factory preset if the device has no MIDI Out or does not send it
+func (d *AkaiMidimix) Identify(c chan []byte, out drivers.Out) error {
+	d.presetData = factoryPreset
+	if out == nil {
+		d.log.Warn().Msg("No MIDI Out, using the factory preset")
+		return nil
+	}
+	_, presetData, err := d.presetRequestMessage().Send(c, out, d.log)
+	if err != nil {
+		d.log.Warn().Msgf("Could not fetch preset, using the factory preset: %s", err)
+		return nil
+	}
+	d.log.Debug().Msgf("Preset % X", presetData)
+	d.presetData = presetData
+	return nil
+}
+
+// controlIndex returns the index of the control at path in the preset data
+func controlIndex(path string) (int, bool) {
+	if matches := stripRe.FindStringSubmatch(path); matches != nil {
+		strip, _ := strconv.Atoi(matches[1])
+		for i, control := range stripControls {
+			if control == matches[2] {
+				return (strip-1)*stripSize + i*controlSize, true
+			}
+		}
+	}
+	if masterRe.MatchString(path) {
+		return masterIndex, true
+	}
+	return 0, false
+}
+
+func (d *AkaiMidimix) Resolve(path string) (configuration.MidiMessage, error) {
+	if d.presetData == nil {
+		return configuration.MidiMessage{}, fmt.Errorf("preset not fetched")
+	}
+	index, ok := controlIndex(path)
+	if !ok {
+		return configuration.MidiMessage{}, fmt.Errorf("no such %s control", deviceType)
+	}
+	channel := d.presetData[index]
+	number := d.presetData[index+1]
+	message := configuration.MidiMessage{
+		Channel:  configuration.ValueSet{channel},
+		MinValue: 0x0,
+		MaxValue: 0x7f,
+	}
+	if isButton(path) {
+		message.Type = configuration.Note
+		message.Note = configuration.ValueSet{number}
+	} else {
+		message.Type = configuration.ControlChange
+		message.Controller = configuration.ValueSet{number}
+	}
+	return message, nil
+}
+
+func isButton(path string) bool {
+	matches := stripRe.FindStringSubmatch(path)
+	return matches != nil && (matches[2] == "Mute" || matches[2] == "Solo" || matches[2] == "RecArm")
+}
+
+// Feedback lights the mute and rec arm button LEDs, the solo buttons sharing
+// the mute LEDs
+func (d *AkaiMidimix) Feedback(out drivers.Out, path string, index int, value uint8) error {
+	matches := stripRe.FindStringSubmatch(path)
+	if matches == nil || (matches[2] != "Mute" && matches[2] != "RecArm") || d.presetData == nil {
+		return nil
+	}
+	presetIndex, _ := controlIndex(path)
+	channel := d.presetData[presetIndex]
+	note := d.presetData[presetIndex+1]
+	if value == 0 {
+		return out.Send(midi.NoteOff(channel, note))
+	}
+	return out.Send(midi.NoteOn(channel, note, 0x7f))
+}
@@ -0,0 +1,21 @@
+package akai
+
+import "slices"
+
+// Request returns an Akai SysEx message for the product, with the data length
+// as two 7-bit bytes
+func Request(product byte, command byte, data ...byte) []byte {
+	return slices.Concat(
+		[]byte{
+			0xf0,
+			0x47, // Akai
+			0x7f,
+			product,
+			command,
+			byte(len(data) >> 7 & 0x7f),
+			byte(len(data) & 0x7f),
+		},
+		data,
+		[]byte{0xf7},
+	)
+}
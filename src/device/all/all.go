@@ -0,0 +1,14 @@
+// Package all registers all the device drivers
+package all
+
+import (
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/akai/lpd8"
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/akai/lpd8mk2"
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/akai/midimix"
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/behringer/xtouchmini"
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/korg/nanokontrol"
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/korg/nanokontrol2"
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/korg/nanokontrolstudio"
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/mackie/mcu"
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/novation/launchcontrolxl"
+)
@@ -0,0 +1,275 @@
+// MIDI implementation based on the Behringer X-Touch Mini quick start guide
+
+package behringerXTouchMini
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+const (
+	// Device in standard mode
+	deviceType configuration.MidiDeviceType = "BehringerXTouchMini"
+	// Device in Mackie Control mode
+	mcDeviceType configuration.MidiDeviceType = "BehringerXTouchMiniMC"
+)
+
+func init() {
+	device.Register(deviceType, func(name string) device.Driver {
+		return New(name, false)
+	})
+	device.Register(mcDeviceType, func(name string) device.Driver {
+		return New(name, true)
+	})
+}
+
+const (
+	// Channel of the global commands, e.g. the mode selection
+	globalChannel = 0
+	// Channel of the controls in standard mode
+	standardChannel = 10
+	// Mode selection controller, 0 for standard mode, 1 for MC mode
+	modeController = 127
+)
+
+// Standard mode control numbers of layers A and B
+var standardLayers = []struct {
+	program       uint8
+	encoders      uint8
+	encoderPushes uint8
+	buttons       uint8
+	fader         uint8
+}{
+	{program: 0, encoders: 1, encoderPushes: 0, buttons: 8, fader: 9},
+	{program: 1, encoders: 11, encoderPushes: 24, buttons: 32, fader: 10},
+}
+
+// MC mode button notes, top row then bottom row
+var mcButtonNotes = []uint8{
+	89, 90, 40, 41, 42, 43, 44, 45,
+	87, 88, 91, 92, 86, 93, 94, 95,
+}
+
+const (
+	mcEncoderPushNote = 32
+	mcLayerNote       = 84
+	// Relative encoder controllers in MC mode
+	mcEncoderController = 16
+	// Fader pitch bend channel in MC mode
+	mcFaderChannel = 8
+	// Encoder LED ring controllers in MC mode
+	mcRingController = 48
+	// LED ring fan mode in MC mode
+	mcRingFan = 0x20
+)
+
+var (
+	layerRe       = regexp.MustCompile("^Layer([AB])$")
+	encoderRe     = regexp.MustCompile("^(?:Layer([AB])/)?Encoder([1-8])$")
+	encoderPushRe = regexp.MustCompile("^(?:Layer([AB])/)?Encoder([1-8])/Push$")
+	buttonRe      = regexp.MustCompile("^(?:Layer([AB])/)?Button([1-9]|1[0-6])$")
+	faderRe       = regexp.MustCompile("^(?:Layer([AB])/)?Fader$")
+)
+
+type BehringerXTouchMini struct {
+	log        zerolog.Logger
+	DeviceName string
+	// Mackie Control mode, standard mode otherwise
+	mc bool
+}
+
+func New(name string, mc bool) *BehringerXTouchMini {
+	return &BehringerXTouchMini{
+		log:        log.With().Str("device", "Behringer X-Touch Mini").Logger(),
+		DeviceName: name,
+		mc:         mc,
+	}
+}
+
+func (d *BehringerXTouchMini) ControlPaths() []string {
+	if d.mc {
+		return []string{
+			"Layer[A|B]",
+			"Encoder[1-8]",
+			"Encoder[1-8]/Push",
+			"Button[1-16]",
+			"Fader",
+		}
+	}
+	return []string{
+		"Layer[A|B]",
+		"Layer[A|B]/Encoder[1-8]",
+		"Layer[A|B]/Encoder[1-8]/Push",
+		"Layer[A|B]/Button[1-16]",
+		"Layer[A|B]/Fader",
+		"Encoder[1-8]",
+		"Encoder[1-8]/Push",
+		"Button[1-16]",
+		"Fader",
+	}
+}
+
+// Identify puts the device into standard or MC mode
+func (d *BehringerXTouchMini) Identify(c chan []byte, out drivers.Out) error {
+	if out == nil {
+		d.log.Warn().Msg("No MIDI Out, the device mode is not set")
+		return nil
+	}
+	mode := uint8(0)
+	if d.mc {
+		mode = 1
+	}
+	if err := out.Send(midi.ControlChange(globalChannel, modeController, mode)); err != nil {
+		return fmt.Errorf("could not set device mode: %w", err)
+	}
+	d.log.Info().Msgf("Set MC mode %t", d.mc)
+	return nil
+}
+
+// layers returns the standard mode layers of a control path layer, both
+// layers if it has none
+func layers(layer string) []int {
+	switch layer {
+	case "A":
+		return []int{0}
+	case "B":
+		return []int{1}
+	}
+	return []int{0, 1}
+}
+
+// controlNumbers returns the message type and numbers of the control at
+// path, one per layer the control is on
+func (d *BehringerXTouchMini) controlNumbers(path string) (configuration.MidiMessageType, []uint8, error) {
+	if matches := layerRe.FindStringSubmatch(path); matches != nil {
+		layer := layers(matches[1])[0]
+		if d.mc {
+			return configuration.Note, []uint8{mcLayerNote + uint8(layer)}, nil
+		}
+		return configuration.ProgramChange, []uint8{standardLayers[layer].program}, nil
+	}
+	if matches := encoderPushRe.FindStringSubmatch(path); matches != nil {
+		encoder, _ := strconv.Atoi(matches[2])
+		if d.mc {
+			if matches[1] != "" {
+				return configuration.None, nil, fmt.Errorf("no layers in MC mode")
+			}
+			return configuration.Note, []uint8{mcEncoderPushNote + uint8(encoder-1)}, nil
+		}
+		return configuration.Note, lo.Map(layers(matches[1]), func(layer int, i int) uint8 {
+			return standardLayers[layer].encoderPushes + uint8(encoder-1)
+		}), nil
+	}
+	if matches := buttonRe.FindStringSubmatch(path); matches != nil {
+		button, _ := strconv.Atoi(matches[2])
+		if d.mc {
+			if matches[1] != "" {
+				return configuration.None, nil, fmt.Errorf("no layers in MC mode")
+			}
+			return configuration.Note, []uint8{mcButtonNotes[button-1]}, nil
+		}
+		return configuration.Note, lo.Map(layers(matches[1]), func(layer int, i int) uint8 {
+			return standardLayers[layer].buttons + uint8(button-1)
+		}), nil
+	}
+	if matches := encoderRe.FindStringSubmatch(path); matches != nil {
+		encoder, _ := strconv.Atoi(matches[2])
+		if d.mc {
+			if matches[1] != "" {
+				return configuration.None, nil, fmt.Errorf("no layers in MC mode")
+			}
+			return configuration.ControlChange, []uint8{mcEncoderController + uint8(encoder-1)}, nil
+		}
+		return configuration.ControlChange, lo.Map(layers(matches[1]), func(layer int, i int) uint8 {
+			return standardLayers[layer].encoders + uint8(encoder-1)
+		}), nil
+	}
+	if matches := faderRe.FindStringSubmatch(path); matches != nil {
+		if d.mc {
+			if matches[1] != "" {
+				return configuration.None, nil, fmt.Errorf("no layers in MC mode")
+			}
+			return configuration.PitchBend, []uint8{0}, nil
+		}
+		return configuration.ControlChange, lo.Map(layers(matches[1]), func(layer int, i int) uint8 {
+			return standardLayers[layer].fader
+		}), nil
+	}
+	return configuration.None, nil, fmt.Errorf("no such %s control", deviceType)
+}
+
+func (d *BehringerXTouchMini) channel() uint8 {
+	if d.mc {
+		return globalChannel
+	}
+	return standardChannel
+}
+
+func (d *BehringerXTouchMini) Resolve(path string) (configuration.MidiMessage, error) {
+	messageType, numbers, err := d.controlNumbers(path)
+	if err != nil {
+		return configuration.MidiMessage{}, err
+	}
+	message := configuration.MidiMessage{
+		Type:     messageType,
+		Channel:  configuration.ValueSet{d.channel()},
+		MinValue: 0x0,
+		MaxValue: 0x7f,
+	}
+	switch messageType {
+	case configuration.Note:
+		message.Note = numbers
+	case configuration.ControlChange:
+		message.Controller = numbers
+	case configuration.ProgramChange:
+		message.Program = numbers
+	case configuration.PitchBend:
+		message.Channel = configuration.ValueSet{mcFaderChannel}
+	}
+	if d.mc && encoderRe.MatchString(path) {
+		// V-Pot ticks, 1-15 clockwise and 65-79 counterclockwise
+		message.Relative = true
+		message.RelativeEncoding = configuration.SignMagnitude
+	}
+	return message, nil
+}
+
+// Feedback lights the LED ring of an encoder or the LED of a button. In
+// standard mode the control message is sent back, which updates the control
+// on its layer, in MC mode the LEDs are set directly.
+func (d *BehringerXTouchMini) Feedback(out drivers.Out, path string, index int, value uint8) error {
+	messageType, numbers, err := d.controlNumbers(path)
+	if err != nil {
+		return err
+	}
+	if index >= len(numbers) {
+		return fmt.Errorf("no control %d at %s", index, path)
+	}
+	number := numbers[index]
+	var message midi.Message
+	switch {
+	case layerRe.MatchString(path), faderRe.MatchString(path):
+		// Layers are selected on the device, the fader is not motorized
+		return nil
+	case d.mc && encoderPushRe.MatchString(path):
+		position := uint8(math.Round(float64(value) * 11 / 0x7f))
+		message = midi.ControlChange(globalChannel, mcRingController+number-mcEncoderPushNote, mcRingFan|position)
+	case messageType == configuration.Note && value == 0:
+		message = midi.NoteOff(d.channel(), number)
+	case messageType == configuration.Note:
+		message = midi.NoteOn(d.channel(), number, 0x7f)
+	default:
+		message = midi.ControlChange(d.channel(), number, value)
+	}
+	return out.Send(message)
+}
@@ -0,0 +1,214 @@
+// Device drivers described by YAML definition files, for controllers without
+// SysEx
+
+package definition
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+	"gopkg.in/yaml.v3"
+)
+
+// Device definition file content
+type Definition struct {
+	// Device type used in the configuration
+	Type     configuration.MidiDeviceType `yaml:"type"`
+	Controls []Control                    `yaml:"controls"`
+}
+
+// Control of a device, e.g. a knob, a button or a key
+type Control struct {
+	// Control path, e.g. Knob/Volume
+	Path    string                        `yaml:"path"`
+	Type    configuration.MidiMessageType `yaml:"type"`
+	Channel uint8                         `yaml:"channel"`
+	// Note, controller or program number
+	Number   uint8     `yaml:"number"`
+	MinValue uint8     `yaml:"minValue"`
+	MaxValue uint8     `yaml:"maxValue"`
+	Feedback *Feedback `yaml:"feedback"`
+}
+
+// MIDI message showing a value on a control, e.g. lighting its LED
+type Feedback struct {
+	Type    configuration.MidiMessageType `yaml:"type"`
+	Channel uint8                         `yaml:"channel"`
+	// Note or controller number, the value being the velocity or the
+	// controller value
+	Number uint8 `yaml:"number"`
+}
+
+// Dirs returns the directories the definition files are loaded from
+func Dirs() []string {
+	homeDir, _ := os.UserHomeDir()
+	return []string{
+		"./devices",
+		fmt.Sprintf("%s/.config/pamixermidicontrol/devices", homeDir),
+	}
+}
+
+// Load registers a device type for each definition file found in dirs
+func Load(dirs []string) error {
+	var errs []error
+	for _, dir := range dirs {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, path := range paths {
+			definition, err := read(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not load device definition %s: %w", path, err))
+				continue
+			}
+			if lo.Contains(device.Types(), string(definition.Type)) {
+				errs = append(errs, fmt.Errorf("could not load device definition %s: device type %s already exists", path, definition.Type))
+				continue
+			}
+			device.Register(definition.Type, func(name string) device.Driver {
+				return New(name, definition)
+			})
+			log.Info().Msgf("Loaded device definition %s from %s", definition.Type, path)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func read(path string) (Definition, error) {
+	var definition Definition
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return definition, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&definition); err != nil {
+		return definition, err
+	}
+	if definition.Type == "" {
+		return definition, fmt.Errorf("missing device type")
+	}
+	paths := map[string]bool{}
+	for _, control := range definition.Controls {
+		if control.Path == "" {
+			return definition, fmt.Errorf("control with no path")
+		}
+		if paths[control.Path] {
+			return definition, fmt.Errorf("control %s defined twice", control.Path)
+		}
+		paths[control.Path] = true
+		if err := checkMessage(control.Type, control.Channel, control.Number); err != nil {
+			return definition, fmt.Errorf("control %s: %w", control.Path, err)
+		}
+		if control.Feedback != nil {
+			if control.Feedback.Type == configuration.ProgramChange {
+				return definition, fmt.Errorf("control %s: feedback can not be a program change", control.Path)
+			}
+			if err := checkMessage(control.Feedback.Type, control.Feedback.Channel, control.Feedback.Number); err != nil {
+				return definition, fmt.Errorf("control %s feedback: %w", control.Path, err)
+			}
+		}
+	}
+	return definition, nil
+}
+
+func checkMessage(messageType configuration.MidiMessageType, channel uint8, number uint8) error {
+	switch messageType {
+	case configuration.Note, configuration.ControlChange, configuration.ProgramChange:
+	default:
+		return fmt.Errorf("bad message type %q", messageType)
+	}
+	if channel > 15 {
+		return fmt.Errorf("bad channel %d", channel)
+	}
+	if number > 0x7f {
+		return fmt.Errorf("bad number %d", number)
+	}
+	return nil
+}
+
+// Driver of a device described by a definition
+type DefinitionDriver struct {
+	log        zerolog.Logger
+	DeviceName string
+	definition Definition
+	controls   map[string]Control
+}
+
+func New(name string, definition Definition) *DefinitionDriver {
+	return &DefinitionDriver{
+		log:        log.With().Str("device", string(definition.Type)).Logger(),
+		DeviceName: name,
+		definition: definition,
+		controls: lo.KeyBy(definition.Controls, func(control Control) string {
+			return control.Path
+		}),
+	}
+}
+
+// Identify does nothing, definitions are static
+func (d *DefinitionDriver) Identify(c chan []byte, out drivers.Out) error {
+	return nil
+}
+
+func (d *DefinitionDriver) ControlPaths() []string {
+	return lo.Map(d.definition.Controls, func(control Control, i int) string {
+		return control.Path
+	})
+}
+
+func (d *DefinitionDriver) Resolve(path string) (configuration.MidiMessage, error) {
+	control, ok := d.controls[path]
+	if !ok {
+		return configuration.MidiMessage{}, fmt.Errorf("no such %s control", d.definition.Type)
+	}
+	message := configuration.MidiMessage{
+		Type:     control.Type,
+		Channel:  configuration.ValueSet{control.Channel},
+		MinValue: control.MinValue,
+		MaxValue: control.MaxValue,
+	}
+	switch control.Type {
+	case configuration.Note:
+		message.Note = configuration.ValueSet{control.Number}
+	case configuration.ControlChange:
+		message.Controller = configuration.ValueSet{control.Number}
+	case configuration.ProgramChange:
+		message.Program = configuration.ValueSet{control.Number}
+	}
+	return message, nil
+}
+
+// Feedback sends the feedback message of the control at path, if it has one
+func (d *DefinitionDriver) Feedback(out drivers.Out, path string, index int, value uint8) error {
+	control, ok := d.controls[path]
+	if !ok || control.Feedback == nil {
+		return nil
+	}
+	feedback := control.Feedback
+	var message midi.Message
+	switch feedback.Type {
+	case configuration.Note:
+		if value == 0 {
+			message = midi.NoteOff(feedback.Channel, feedback.Number)
+		} else {
+			message = midi.NoteOn(feedback.Channel, feedback.Number, value)
+		}
+	case configuration.ControlChange:
+		message = midi.ControlChange(feedback.Channel, feedback.Number, value)
+	}
+	d.log.Debug().Msgf("Sending feedback %s for %s", message, path)
+	return out.Send(message)
+}
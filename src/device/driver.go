@@ -0,0 +1,208 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// ErrNoOut is returned by drivers needing the MIDI Out port to identify the
+// device when there is none
+var ErrNoOut = errors.New("no MIDI Out")
+
+// ErrBusy is returned by feedback drivers when a control can not show a value
+// for now, e.g. a motor fader touched by the user. The feedback is sent again
+// on the next update.
+var ErrBusy = errors.New("control busy")
+
+// ErrInactive is returned by drivers resolving a control path which is not on
+// the current device setup, e.g. on another program. The rule is ignored until
+// the setup changes.
+var ErrInactive = errors.New("control not on the current setup")
+
+// Driver resolves the control paths of a controller model to MIDI messages
+type Driver interface {
+	// Identify queries the device, e.g. for its current scene, before the
+	// control paths are resolved. c receives the SysEx messages from the
+	// device, out is nil if the device has no MIDI Out.
+	Identify(c chan []byte, out drivers.Out) error
+	// ControlPaths lists the supported control paths, e.g. "Knob[1-8]"
+	ControlPaths() []string
+	// Resolve returns the MIDI message sent by the control at path
+	Resolve(path string) (configuration.MidiMessage, error)
+}
+
+// Driver of a device showing values, e.g. with LEDs
+type FeedbackDriver interface {
+	Driver
+	// Feedback shows value, from 0 to 127, on the control at path, index
+	// being the position of the control among the ones of the path
+	Feedback(out drivers.Out, path string, index int, value uint8) error
+}
+
+// Driver of a device showing target names, e.g. on scribble strips
+type LabelDriver interface {
+	Driver
+	// Label shows the name and volume of the target of the control at path,
+	// name is empty if there is no target
+	Label(out drivers.Out, path string, index int, name string, volume float32) error
+}
+
+// Driver handling the messages received from the device, e.g. to track
+// fader touches or bank buttons
+type InputDriver interface {
+	Driver
+	Input(messageType configuration.MidiMessageType, channel uint8, number uint8, value uint8)
+}
+
+// Driver of a device with banks, shifting the controls over the targets
+type BankDriver interface {
+	Driver
+	// BankOffset is added to the index of the controls resolved from
+	// control paths
+	BankOffset() int
+}
+
+// Driver of a device with layers selected on the device, e.g. templates, the
+// feedback being shown on the current layer only
+type LayerDriver interface {
+	Driver
+	// Layer returns the current layer, the feedback is sent again when it
+	// changes
+	Layer() int
+}
+
+// Driver handling the SysEx messages sent by the device on its own, e.g. on
+// template changes
+type SysExInputDriver interface {
+	Driver
+	// SysExInput returns true if it handled the message, which is then not
+	// taken as a request response
+	SysExInput(sysEx []byte) bool
+}
+
+// Driver of a device whose scene, or program, can be read and written
+type SceneDriver interface {
+	Driver
+	ReadScene(c chan []byte, out drivers.Out) ([]byte, error)
+	WriteScene(c chan []byte, out drivers.Out, scene []byte) error
+}
+
+// Driver of a device whose scene can be edited as YAML
+type SceneEditor interface {
+	SceneDriver
+	// EncodeSceneYaml returns scene data as a human readable YAML
+	EncodeSceneYaml(scene []byte) ([]byte, error)
+	// DecodeSceneYaml returns the scene data of a YAML scene after checking
+	// it, the bytes it does not set being the ones of base, or zero if base
+	// is nil
+	DecodeSceneYaml(content []byte, base []byte) ([]byte, error)
+	// SaveScene writes the current scene to the device memory
+	SaveScene(c chan []byte, out drivers.Out) error
+}
+
+// Driver of a device with programs stored in its memory
+type ProgramDriver interface {
+	Driver
+	// Programs returns the number of programs, numbered from 1
+	Programs() int
+	ReadProgram(c chan []byte, out drivers.Out, number uint8) (configuration.DeviceProgram, error)
+	WriteProgram(c chan []byte, out drivers.Out, program configuration.DeviceProgram) error
+	// SelectProgram makes a program the active one
+	SelectProgram(c chan []byte, out drivers.Out, number uint8) error
+}
+
+// Driver of a device whose setup, e.g. its scene or program, can be changed
+// on the device while running
+type SetupWatcher interface {
+	Driver
+	// SetupChanged queries the device setup and returns true if it changed
+	// since the last check, the control paths being then resolved again
+	SetupChanged(c chan []byte, out drivers.Out) (bool, error)
+}
+
+// Driver of a device whose state can be restored on shutdown
+type StateRestorer interface {
+	RestoreState(c chan []byte, out drivers.Out) error
+}
+
+// Factory creates a driver for the device named name
+type Factory func(name string) Driver
+
+var registry = map[configuration.MidiDeviceType]Factory{}
+
+// Register makes a driver available for a device type, drivers register
+// from their package init function
+func Register(deviceType configuration.MidiDeviceType, factory Factory) {
+	if _, ok := registry[deviceType]; ok {
+		panic(fmt.Sprintf("device type %s registered twice", deviceType))
+	}
+	registry[deviceType] = factory
+}
+
+// New creates the driver of a device type, false if there is none
+func New(deviceType configuration.MidiDeviceType, name string) (Driver, bool) {
+	factory, ok := registry[deviceType]
+	if !ok {
+		return nil, false
+	}
+	return factory(name), true
+}
+
+// Types returns the device types, Generic included
+func Types() []string {
+	types := lo.Map(lo.Keys(registry), func(deviceType configuration.MidiDeviceType, i int) string {
+		return string(deviceType)
+	})
+	slices.Sort(types)
+	return append([]string{string(configuration.Generic)}, types...)
+}
+
+// ListControlPaths lists the control paths of the device types with a driver
+func ListControlPaths() {
+	deviceTypes := lo.Keys(registry)
+	slices.Sort(deviceTypes)
+	for _, deviceType := range deviceTypes {
+		driver := registry[deviceType]("")
+		for _, path := range driver.ControlPaths() {
+			log.Info().Msgf("Found %s control:\t%s", deviceType, path)
+		}
+	}
+}
+
+// ResolveRules resolves the control paths of the rules with the driver,
+// rules with unknown control paths are dropped
+func ResolveRules(driver Driver, rules []configuration.Rule) []configuration.Rule {
+	var resolvedRules []configuration.Rule
+	for _, rule := range rules {
+		if rule.MidiMessage.DeviceControlPath == "" {
+			resolvedRules = append(resolvedRules, rule)
+			continue
+		}
+		message, err := driver.Resolve(rule.MidiMessage.DeviceControlPath)
+		if errors.Is(err, ErrInactive) {
+			log.Debug().Msgf("Ignoring device control path %s: %s", rule.MidiMessage.DeviceControlPath, err)
+			continue
+		}
+		if err != nil {
+			log.Warn().Msgf("Unknown device control path %s: %s", rule.MidiMessage.DeviceControlPath, err)
+			continue
+		}
+		rule.MidiMessage.Type = message.Type
+		rule.MidiMessage.Channel = message.Channel
+		rule.MidiMessage.Note = message.Note
+		rule.MidiMessage.Controller = message.Controller
+		rule.MidiMessage.Program = message.Program
+		rule.MidiMessage.MinValue = message.MinValue
+		rule.MidiMessage.MaxValue = message.MaxValue
+		rule.MidiMessage.Relative = message.Relative
+		rule.MidiMessage.RelativeEncoding = message.RelativeEncoding
+		resolvedRules = append(resolvedRules, rule)
+	}
+	return resolvedRules
+}
@@ -0,0 +1,76 @@
+// Scene data layout based on the nanoKONTROL MIDI implementation
+
+package korgNanokontrol
+
+import (
+	"fmt"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/fluciotto/pamixermidicontrol/src/device/korg"
+)
+
+const deviceType configuration.MidiDeviceType = "KorgNanoKontrol"
+
+func init() {
+	device.Register(deviceType, func(name string) device.Driver {
+		return korg.NewSceneDriver(name, layout())
+	})
+}
+
+const (
+	groups = 9
+	// Index of the first group in the scene data, and size of a group: its
+	// channel then its controls
+	firstGroupIndex = 3
+	groupSize       = 25
+	// Index of the transport buttons in the scene data, starting with their
+	// channel
+	transportIndex = firstGroupIndex + groups*groupSize
+	// Size of a control data
+	controlSize = 6
+)
+
+// Controls of a group, in scene data order
+var groupControls = []struct {
+	name       string
+	continuous bool
+}{
+	{name: "Slider", continuous: true},
+	{name: "Knob", continuous: true},
+	{name: "ButtonA"},
+	{name: "ButtonB"},
+}
+
+// Transport buttons, in scene data order
+var transportControls = []string{"Rewind", "Play", "FastForward", "Loop", "Stop", "Rec"}
+
+func layout() korg.Layout {
+	var controls []korg.ControlLayout
+	for group := 0; group < groups; group++ {
+		groupIndex := firstGroupIndex + group*groupSize
+		for i, control := range groupControls {
+			controls = append(controls, korg.ControlLayout{
+				Path:         fmt.Sprintf("Group%d/%s", group+1, control.name),
+				ChannelIndex: groupIndex,
+				Index:        groupIndex + 1 + i*controlSize,
+				Continuous:   control.continuous,
+			})
+		}
+	}
+	for i, control := range transportControls {
+		controls = append(controls, korg.ControlLayout{
+			Path:         "Transport/" + control,
+			ChannelIndex: transportIndex,
+			Index:        transportIndex + 1 + i*controlSize,
+		})
+	}
+	return korg.Layout{
+		DeviceType:         deviceType,
+		Name:               "Korg nanoKontrol",
+		ProductID:          []byte{0x00, 0x01, 0x04, 0x00},
+		Scenes:             4,
+		GlobalChannelIndex: 0,
+		Controls:           controls,
+	}
+}
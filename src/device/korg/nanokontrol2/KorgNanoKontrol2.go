@@ -3,9 +3,6 @@ package korgNanokontrol2
 import (
 	"encoding/binary"
 	"fmt"
-	"regexp"
-	"slices"
-	"strconv"
 
 	"github.com/fluciotto/pamixermidicontrol/src/configuration"
 	"github.com/fluciotto/pamixermidicontrol/src/device"
@@ -16,15 +13,25 @@ import (
 	"gitlab.com/gomidi/midi/v2/sysex"
 )
 
+const deviceType configuration.MidiDeviceType = "KorgNanoKontrol2"
+
+func init() {
+	device.Register(deviceType, func(name string) device.Driver {
+		return New(name)
+	})
+}
+
+// KorgNanoKontrol2 resolves the control paths from the current scene, and
+// edits the scene
 type KorgNanoKontrol2 struct {
-	log        zerolog.Logger
-	DeviceName string
+	*korg.SceneDriver
+	log zerolog.Logger
 }
 
 func New(name string) *KorgNanoKontrol2 {
 	return &KorgNanoKontrol2{
-		log:        log.With().Str("device", "Korg nanoKontrol2").Logger(),
-		DeviceName: name,
+		SceneDriver: korg.NewSceneDriver(name, layout()),
+		log:         log.With().Str("device", "Korg nanoKontrol2").Logger(),
 	}
 }
 
@@ -108,63 +115,6 @@ func (d *KorgNanoKontrol2) modeMessage(channel byte) *device.SysExMessage {
 	return device.NewSysExMessage(request, responseHandler)
 }
 
-func (d *KorgNanoKontrol2) sceneDumpRequestMessage(channel byte) *device.SysExMessage {
-	request := []byte{
-		0xf0,
-		0x42, // Korg
-		0x40 + channel&0x0f,
-		0x00, 0x01, 0x13, 0x00, // nanoKontrol2 ID
-		0x1f, // Data dump request
-		0x10, // Current scene data dump request
-		0x00,
-		0xf7,
-	}
-	responseHandler := func(bytes []byte) (rawData []byte, processedData []byte, err error) {
-		log := d.log.With().Str("SysEx", "sceneDumpMessage").Logger()
-		if len(bytes) != 402-2 {
-			log.Error().Msgf("Scene dump request response has a bad length: %d", len(bytes))
-			return []byte{}, []byte{}, fmt.Errorf("scene dump request response has a bad length: %d", len(bytes))
-		}
-		sceneMidiData := bytes[12:]
-		sceneData := korg.MidiDataToData(sceneMidiData)
-		return bytes, sceneData, nil
-	}
-	return device.NewSysExMessage(request, responseHandler)
-}
-
-func (d *KorgNanoKontrol2) sceneDumpMessage(channel byte, sceneData []byte) *device.SysExMessage {
-	if len(sceneData) != 339 {
-		panic(fmt.Sprintf("Scene data has a bad length %d", len(sceneData)))
-	}
-	request := slices.Concat(
-		[]byte{
-			0xf0,
-			0x42, // Korg
-			0x40 + channel&0x0f,
-			0x00, 0x01, 0x13, 0x00, // nanoKontrol2 ID
-			0x7f, // Data dump commad
-			0x7f, //
-			0x02,
-			0x03,
-			0x05,
-			0x40, // Current scene data dump
-		},
-		sceneData,
-		[]byte{0xf7},
-	)
-	responseHandler := func(bytes []byte) (rawData []byte, processedData []byte, err error) {
-		log := d.log.With().Str("SysEx", "sceneSaveMessage").Logger()
-		if len(bytes) != 11-2 {
-			log.Error().Msgf("Scene dump response has a bad length: %d", len(bytes))
-			return []byte{}, []byte{}, fmt.Errorf("scene dump response has a bad length: %d", len(bytes))
-		}
-		result := bytes[7] // 0x23 OK, 0x24 Error
-		log.Info().Msgf("Scene dump result 0x%X", result)
-		return bytes, nil, nil
-	}
-	return device.NewSysExMessage(request, responseHandler)
-}
-
 func (d *KorgNanoKontrol2) sceneWriteMessage(channel byte) *device.SysExMessage {
 	request := []byte{
 		0xf0,
@@ -184,196 +134,84 @@ func (d *KorgNanoKontrol2) sceneWriteMessage(channel byte) *device.SysExMessage
 		}
 		result := bytes[7] // 0x21 OK, 0x22 Error
 		log.Info().Msgf("Scene write result 0x%X", result)
+		if result != 0x21 {
+			return bytes, nil, fmt.Errorf("scene write failed with result 0x%X", result)
+		}
 		return bytes, nil, nil
 	}
 	return device.NewSysExMessage(request, responseHandler)
 }
 
-func (d *KorgNanoKontrol2) UpdateRules(
-	rules []configuration.Rule,
-	c chan []byte,
-	out drivers.Out,
-) (updatedRules []configuration.Rule) {
-	// Fetch scene data from device
-	_, sceneData, err := d.sceneDumpRequestMessage(0).Send(c, out, d.log)
-	if err != nil {
-		panic(err)
+var (
+	// Offsets of the controls in a group scene data
+	groupControlOffsets = map[string]int{
+		"Slider": 1,
+		"Knob":   7,
+		"Solo":   13,
+		"Mute":   19,
+		"Record": 25,
 	}
-	var assignTypeToMidiMessageType = func(assignType byte) configuration.MidiMessageType {
-		if assignType == 1 {
-			return configuration.ControlChange
-		} else if assignType == 2 {
-			return configuration.Note
-		}
-		return configuration.None
+	// Indexes of the transport buttons in the scene data
+	transportControlIndexes = map[string]int{
+		"Track/Prev":  252,
+		"Track/Next":  258,
+		"Cycle":       264,
+		"Marker/Set":  270,
+		"Marker/Prev": 276,
+		"Marker/Next": 282,
+		"Rewind":      288,
+		"FastForward": 294,
+		"Stop":        300,
+		"Play":        306,
+		"Rec":         312,
 	}
-	// Get global MIDI channel from scene data
-	globalMidiChannel := sceneData[0]
-	// Update rules with scene data
-	for _, rule := range rules {
-		if rule.MidiMessage.DeviceName != d.DeviceName {
-			continue
-		}
-		if rule.MidiMessage.DeviceControlPath != "" {
-			//
-			groupRe := regexp.MustCompile("^Group([1-8])/(Slider|Knob|Solo|Mute|Record)$")
-			transportRe := regexp.MustCompile("^Transport/.*")
-			transportTrackRe := regexp.MustCompile("^Transport/Track/(Prev|Next)$")
-			transportCycleRe := regexp.MustCompile("^Transport/Cycle$")
-			transportMarkerRe := regexp.MustCompile("^Transport/Marker/(Set|Prev|Next)$")
-			transportBottomRe := regexp.MustCompile("^Transport/(Rewind|FastForward|Stop|Play|Rec)$")
+)
 
-			//
-			if groupRe.MatchString(rule.MidiMessage.DeviceControlPath) {
-				matches := groupRe.FindStringSubmatch(rule.MidiMessage.DeviceControlPath)
-				groupNumber64, _ := strconv.ParseUint(matches[1], 10, 8)
-				groupNumber := uint8(groupNumber64)
-				control := matches[2]
-				sceneDataGroupIndex := 3 + (groupNumber-1)*31
-				// Update rule
-				rule.MidiMessage.Type = configuration.ControlChange
-				rule.MidiMessage.Channel = sceneData[sceneDataGroupIndex]
-				if rule.MidiMessage.Channel == 16 {
-					rule.MidiMessage.Channel = globalMidiChannel
-				}
-				if control == "Slider" {
-					rule.MidiMessage.Controller = sceneData[sceneDataGroupIndex+3]
-					rule.MidiMessage.MinValue = sceneData[sceneDataGroupIndex+4]
-					rule.MidiMessage.MaxValue = sceneData[sceneDataGroupIndex+5]
-				} else if control == "Knob" {
-					rule.MidiMessage.Controller = sceneData[sceneDataGroupIndex+9]
-					rule.MidiMessage.MinValue = sceneData[sceneDataGroupIndex+10]
-					rule.MidiMessage.MaxValue = sceneData[sceneDataGroupIndex+11]
-				} else if control == "Solo" {
-					rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataGroupIndex+13])
-					rule.MidiMessage.Controller = sceneData[sceneDataGroupIndex+15]
-					rule.MidiMessage.MinValue = sceneData[sceneDataGroupIndex+16]
-					rule.MidiMessage.MaxValue = sceneData[sceneDataGroupIndex+17]
-				} else if control == "Mute" {
-					rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataGroupIndex+19])
-					rule.MidiMessage.Controller = sceneData[sceneDataGroupIndex+21]
-					rule.MidiMessage.MinValue = sceneData[sceneDataGroupIndex+22]
-					rule.MidiMessage.MaxValue = sceneData[sceneDataGroupIndex+23]
-				} else if control == "Record" {
-					rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataGroupIndex+25])
-					rule.MidiMessage.Controller = sceneData[sceneDataGroupIndex+27]
-					rule.MidiMessage.MinValue = sceneData[sceneDataGroupIndex+28]
-					rule.MidiMessage.MaxValue = sceneData[sceneDataGroupIndex+29]
-				}
-				updatedRules = append(updatedRules, rule)
-			} else if transportRe.MatchString(rule.MidiMessage.DeviceControlPath) {
-				sceneDataTransportIndex := 251
-				rule.MidiMessage.Channel = sceneData[sceneDataTransportIndex]
-				if rule.MidiMessage.Channel == 16 {
-					rule.MidiMessage.Channel = globalMidiChannel
-				}
-				if transportTrackRe.MatchString(rule.MidiMessage.DeviceControlPath) {
-					matches := transportTrackRe.FindStringSubmatch(rule.MidiMessage.DeviceControlPath)
-					control := matches[1]
-					if control == "Prev" {
-						sceneDataIndex := 252
-						rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataIndex])
-						rule.MidiMessage.Note = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.Controller = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.MinValue = sceneData[sceneDataIndex+3]
-						rule.MidiMessage.MaxValue = sceneData[sceneDataIndex+4]
-					} else if control == "Next" {
-						sceneDataIndex := 258
-						rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataIndex])
-						rule.MidiMessage.Note = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.Controller = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.MinValue = sceneData[sceneDataIndex+3]
-						rule.MidiMessage.MaxValue = sceneData[sceneDataIndex+4]
-					}
-					updatedRules = append(updatedRules, rule)
-				} else if transportCycleRe.MatchString(rule.MidiMessage.DeviceControlPath) {
-					sceneDataIndex := 264
-					rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataIndex])
-					rule.MidiMessage.Note = sceneData[sceneDataIndex+2]
-					rule.MidiMessage.Controller = sceneData[sceneDataIndex+2]
-					rule.MidiMessage.MinValue = sceneData[sceneDataIndex+3]
-					rule.MidiMessage.MaxValue = sceneData[sceneDataIndex+4]
-					updatedRules = append(updatedRules, rule)
-				} else if transportMarkerRe.MatchString(rule.MidiMessage.DeviceControlPath) {
-					matches := transportMarkerRe.FindStringSubmatch(rule.MidiMessage.DeviceControlPath)
-					control := matches[1]
-					if control == "Set" {
-						sceneDataIndex := 270
-						rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataIndex])
-						rule.MidiMessage.Note = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.Controller = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.MinValue = sceneData[sceneDataIndex+3]
-						rule.MidiMessage.MaxValue = sceneData[sceneDataIndex+4]
-					} else if control == "Prev" {
-						sceneDataIndex := 276
-						rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataIndex])
-						rule.MidiMessage.Note = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.Controller = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.MinValue = sceneData[sceneDataIndex+3]
-						rule.MidiMessage.MaxValue = sceneData[sceneDataIndex+4]
-					} else if control == "Next" {
-						sceneDataIndex := 282
-						rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataIndex])
-						rule.MidiMessage.Note = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.Controller = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.MinValue = sceneData[sceneDataIndex+3]
-						rule.MidiMessage.MaxValue = sceneData[sceneDataIndex+4]
-					}
-					updatedRules = append(updatedRules, rule)
-				} else if transportBottomRe.MatchString(rule.MidiMessage.DeviceControlPath) {
-					matches := transportBottomRe.FindStringSubmatch(rule.MidiMessage.DeviceControlPath)
-					control := matches[1]
-					// Update rule
-					rule.MidiMessage.Channel = sceneData[sceneDataTransportIndex]
-					if rule.MidiMessage.Channel == 16 {
-						rule.MidiMessage.Channel = globalMidiChannel
-					}
-					if control == "Rewind" {
-						sceneDataIndex := 288
-						rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataIndex])
-						rule.MidiMessage.Note = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.Controller = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.MinValue = sceneData[sceneDataIndex+3]
-						rule.MidiMessage.MaxValue = sceneData[sceneDataIndex+4]
-					} else if control == "FastForward" {
-						sceneDataIndex := 294
-						rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataIndex])
-						rule.MidiMessage.Note = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.Controller = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.MinValue = sceneData[sceneDataIndex+3]
-						rule.MidiMessage.MaxValue = sceneData[sceneDataIndex+4]
-					} else if control == "Stop" {
-						sceneDataIndex := 300
-						rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataIndex])
-						rule.MidiMessage.Note = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.Controller = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.MinValue = sceneData[sceneDataIndex+3]
-						rule.MidiMessage.MaxValue = sceneData[sceneDataIndex+4]
-					} else if control == "Play" {
-						sceneDataIndex := 306
-						rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataIndex])
-						rule.MidiMessage.Note = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.Controller = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.MinValue = sceneData[sceneDataIndex+3]
-						rule.MidiMessage.MaxValue = sceneData[sceneDataIndex+4]
-					} else if control == "Rec" {
-						sceneDataIndex := 312
-						rule.MidiMessage.Type = assignTypeToMidiMessageType(sceneData[sceneDataIndex+1])
-						rule.MidiMessage.Note = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.Controller = sceneData[sceneDataIndex+2]
-						rule.MidiMessage.MinValue = sceneData[sceneDataIndex+3]
-						rule.MidiMessage.MaxValue = sceneData[sceneDataIndex+4]
-					}
-					updatedRules = append(updatedRules, rule)
-				} else {
-					log.Warn().Msgf("Unknown device control path %s", rule.MidiMessage.DeviceControlPath)
-				}
-			} else {
-				log.Warn().Msgf("Unknown device control path %s", rule.MidiMessage.DeviceControlPath)
-			}
-		} else {
-			updatedRules = append(updatedRules, rule)
+func layout() korg.Layout {
+	var controls []korg.ControlLayout
+	for group := 0; group < groups; group++ {
+		groupIndex := firstGroupIndex + group*groupSize
+		for _, control := range (&sceneGroup{}).controls() {
+			controls = append(controls, korg.ControlLayout{
+				Path:         fmt.Sprintf("Group%d/%s", group+1, control.name),
+				ChannelIndex: groupIndex,
+				Index:        groupIndex + groupControlOffsets[control.name],
+				Continuous:   isContinuous(control.name),
+			})
 		}
 	}
-	return updatedRules
+	for _, control := range (&sceneTransport{}).controls() {
+		controls = append(controls, korg.ControlLayout{
+			Path:         "Transport/" + control.name,
+			ChannelIndex: transportChannelIndex,
+			Index:        transportControlIndexes[control.name],
+		})
+	}
+	return korg.Layout{
+		DeviceType:         deviceType,
+		Name:               "Korg nanoKontrol2",
+		ProductID:          []byte{0x00, 0x01, 0x13, 0x00},
+		Scenes:             1,
+		GlobalChannelIndex: globalChannelIndex,
+		Controls:           controls,
+	}
+}
+
+func (d *KorgNanoKontrol2) ControlPaths() []string {
+	return []string{
+		"Group[1-8]/[Slider|Knob|Solo|Mute|Record]",
+		"Transport/Track/[Prev|Next]",
+		"Transport/Cycle",
+		"Transport/Marker/[Set|Prev|Next]",
+		"Transport/[Rewind|FastForward|Stop|Play|Rec]",
+	}
+}
+
+// SaveScene writes the current scene to the device memory, so that it is kept
+// when the device is powered off
+func (d *KorgNanoKontrol2) SaveScene(c chan []byte, out drivers.Out) error {
+	if _, _, err := d.sceneWriteMessage(0).Send(c, out, d.log); err != nil {
+		return fmt.Errorf("could not write scene: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,320 @@
+package korgNanokontrol2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+// Scene data layout
+const (
+	sceneSize          = 339
+	globalChannelIndex = 0
+	controlModeIndex   = 1
+	ledModeIndex       = 2
+	firstGroupIndex    = 3
+	groupSize          = 31
+	groups             = 8
+	// Index of the channel of the transport buttons
+	transportChannelIndex = 251
+	// Channel of the controls using the global channel
+	globalChannel = 16
+)
+
+// Controls data: assign type, button behaviour, note or controller number,
+// min or off value and max or on value
+const (
+	assignOffset    = 0
+	behaviourOffset = 1
+	numberOffset    = 2
+	minValueOffset  = 3
+	maxValueOffset  = 4
+)
+
+var (
+	controlModes = []string{"CC", "Cubase", "DigitalPerformer", "Live", "ProTools", "Sonar"}
+	ledModes     = []string{"Internal", "External"}
+	assignTypes  = []string{"None", "ControlChange", "Note"}
+	behaviours   = []string{"Momentary", "Toggle"}
+)
+
+// Scene data in a human readable form
+type scene struct {
+	GlobalChannel uint8          `yaml:"globalChannel"`
+	ControlMode   string         `yaml:"controlMode"`
+	LedMode       string         `yaml:"ledMode"`
+	Groups        []sceneGroup   `yaml:"groups"`
+	Transport     sceneTransport `yaml:"transport"`
+}
+
+type sceneGroup struct {
+	Channel uint8        `yaml:"channel"`
+	Slider  sceneControl `yaml:"slider"`
+	Knob    sceneControl `yaml:"knob"`
+	Solo    sceneControl `yaml:"solo"`
+	Mute    sceneControl `yaml:"mute"`
+	Record  sceneControl `yaml:"record"`
+}
+
+type sceneTransport struct {
+	Channel     uint8        `yaml:"channel"`
+	TrackPrev   sceneControl `yaml:"trackPrev"`
+	TrackNext   sceneControl `yaml:"trackNext"`
+	Cycle       sceneControl `yaml:"cycle"`
+	MarkerSet   sceneControl `yaml:"markerSet"`
+	MarkerPrev  sceneControl `yaml:"markerPrev"`
+	MarkerNext  sceneControl `yaml:"markerNext"`
+	Rewind      sceneControl `yaml:"rewind"`
+	FastForward sceneControl `yaml:"fastForward"`
+	Stop        sceneControl `yaml:"stop"`
+	Play        sceneControl `yaml:"play"`
+	Rec         sceneControl `yaml:"rec"`
+}
+
+type sceneControl struct {
+	Assign string `yaml:"assign"`
+	// Buttons only
+	Behaviour string `yaml:"behaviour,omitempty"`
+	Number    uint8  `yaml:"number"`
+	// Off and on values of the buttons
+	MinValue uint8 `yaml:"minValue"`
+	MaxValue uint8 `yaml:"maxValue"`
+}
+
+// Scene control with its control path name
+type namedControl struct {
+	name    string
+	control *sceneControl
+}
+
+// controls returns the group controls in scene data order
+func (group *sceneGroup) controls() []namedControl {
+	return []namedControl{
+		{"Slider", &group.Slider},
+		{"Knob", &group.Knob},
+		{"Solo", &group.Solo},
+		{"Mute", &group.Mute},
+		{"Record", &group.Record},
+	}
+}
+
+// controls returns the transport buttons in scene data order
+func (transport *sceneTransport) controls() []namedControl {
+	return []namedControl{
+		{"Track/Prev", &transport.TrackPrev},
+		{"Track/Next", &transport.TrackNext},
+		{"Cycle", &transport.Cycle},
+		{"Marker/Set", &transport.MarkerSet},
+		{"Marker/Prev", &transport.MarkerPrev},
+		{"Marker/Next", &transport.MarkerNext},
+		{"Rewind", &transport.Rewind},
+		{"FastForward", &transport.FastForward},
+		{"Stop", &transport.Stop},
+		{"Play", &transport.Play},
+		{"Rec", &transport.Rec},
+	}
+}
+
+func isContinuous(name string) bool {
+	return name == "Slider" || name == "Knob"
+}
+
+// enumName returns the name of value among names
+func enumName(names []string, value byte, what string) (string, error) {
+	if int(value) >= len(names) {
+		return "", fmt.Errorf("unknown %s %d", what, value)
+	}
+	return names[value], nil
+}
+
+func decodeControl(sceneData []byte, index int, continuous bool) (sceneControl, error) {
+	assign, err := enumName(assignTypes, sceneData[index+assignOffset], "assign type")
+	if err != nil {
+		return sceneControl{}, err
+	}
+	control := sceneControl{
+		Assign:   assign,
+		Number:   sceneData[index+numberOffset],
+		MinValue: sceneData[index+minValueOffset],
+		MaxValue: sceneData[index+maxValueOffset],
+	}
+	if !continuous {
+		if control.Behaviour, err = enumName(behaviours, sceneData[index+behaviourOffset], "button behaviour"); err != nil {
+			return sceneControl{}, err
+		}
+	}
+	return control, nil
+}
+
+// decodeScene returns the human readable form of scene data
+func decodeScene(sceneData []byte) (scene, error) {
+	if len(sceneData) != sceneSize {
+		return scene{}, fmt.Errorf("scene data has a bad length %d", len(sceneData))
+	}
+	var errs []error
+	decodedScene := scene{
+		GlobalChannel: sceneData[globalChannelIndex],
+		Groups:        make([]sceneGroup, groups),
+		Transport:     sceneTransport{Channel: sceneData[transportChannelIndex]},
+	}
+	var err error
+	if decodedScene.ControlMode, err = enumName(controlModes, sceneData[controlModeIndex], "control mode"); err != nil {
+		errs = append(errs, err)
+	}
+	if decodedScene.LedMode, err = enumName(ledModes, sceneData[ledModeIndex], "LED mode"); err != nil {
+		errs = append(errs, err)
+	}
+	for i := range decodedScene.Groups {
+		group := &decodedScene.Groups[i]
+		groupIndex := firstGroupIndex + i*groupSize
+		group.Channel = sceneData[groupIndex]
+		for _, control := range group.controls() {
+			if *control.control, err = decodeControl(sceneData, groupIndex+groupControlOffsets[control.name], isContinuous(control.name)); err != nil {
+				errs = append(errs, fmt.Errorf("group %d %s: %w", i+1, control.name, err))
+			}
+		}
+	}
+	for _, control := range decodedScene.Transport.controls() {
+		if *control.control, err = decodeControl(sceneData, transportControlIndexes[control.name], false); err != nil {
+			errs = append(errs, fmt.Errorf("transport %s: %w", control.name, err))
+		}
+	}
+	return decodedScene, errors.Join(errs...)
+}
+
+// enumValue returns the value of name among names
+func enumValue(names []string, name string, what string) (byte, error) {
+	value := slices.Index(names, name)
+	if value < 0 {
+		return 0, fmt.Errorf("%s %q is not one of %v", what, name, names)
+	}
+	return byte(value), nil
+}
+
+func channelError(channel uint8) error {
+	if channel > globalChannel {
+		return fmt.Errorf("channel %d is not 0-15 or %d for the global channel", channel, globalChannel)
+	}
+	return nil
+}
+
+// encodeControl sets the data of a control, returning its errors prefixed
+// with what
+func encodeControl(sceneData []byte, index int, control sceneControl, continuous bool, what string) []error {
+	var errs []error
+	assign, err := enumValue(assignTypes, control.Assign, "assign type")
+	errs = append(errs, err)
+	if continuous && control.Assign == "Note" {
+		errs = append(errs, fmt.Errorf("sliders and knobs can not send notes"))
+	}
+	if continuous && control.Behaviour != "" {
+		errs = append(errs, fmt.Errorf("sliders and knobs have no behaviour"))
+	}
+	for _, value := range []uint8{control.Number, control.MinValue, control.MaxValue} {
+		if value > 0x7f {
+			errs = append(errs, fmt.Errorf("value %d is not 0-127", value))
+		}
+	}
+	if !continuous {
+		behaviour, err := enumValue(behaviours, control.Behaviour, "behaviour")
+		errs = append(errs, err)
+		sceneData[index+behaviourOffset] = behaviour
+	}
+	sceneData[index+assignOffset] = assign
+	sceneData[index+numberOffset] = control.Number
+	sceneData[index+minValueOffset] = control.MinValue
+	sceneData[index+maxValueOffset] = control.MaxValue
+	return lo.FilterMap(errs, func(err error, i int) (error, bool) {
+		if err == nil {
+			return nil, false
+		}
+		return fmt.Errorf("%s: %w", what, err), true
+	})
+}
+
+// encode returns the scene data of the scene after checking it, the bytes it
+// does not set being the ones of base, or zero if base is nil
+func (s scene) encode(base []byte) ([]byte, error) {
+	sceneData := make([]byte, sceneSize)
+	if base != nil {
+		if len(base) != sceneSize {
+			return nil, fmt.Errorf("scene data has a bad length %d", len(base))
+		}
+		copy(sceneData, base)
+	}
+	var errs []error
+	if s.GlobalChannel > 15 {
+		errs = append(errs, fmt.Errorf("global channel %d is not 0-15", s.GlobalChannel))
+	}
+	sceneData[globalChannelIndex] = s.GlobalChannel
+	controlMode, err := enumValue(controlModes, s.ControlMode, "control mode")
+	errs = append(errs, err)
+	sceneData[controlModeIndex] = controlMode
+	ledMode, err := enumValue(ledModes, s.LedMode, "LED mode")
+	errs = append(errs, err)
+	sceneData[ledModeIndex] = ledMode
+	if len(s.Groups) != groups {
+		errs = append(errs, fmt.Errorf("scene has %d groups instead of %d", len(s.Groups), groups))
+	}
+	for i := range s.Groups[:min(len(s.Groups), groups)] {
+		group := &s.Groups[i]
+		groupIndex := firstGroupIndex + i*groupSize
+		if err := channelError(group.Channel); err != nil {
+			errs = append(errs, fmt.Errorf("group %d: %w", i+1, err))
+		}
+		sceneData[groupIndex] = group.Channel
+		for _, control := range group.controls() {
+			what := fmt.Sprintf("group %d %s", i+1, control.name)
+			errs = append(errs, encodeControl(sceneData, groupIndex+groupControlOffsets[control.name], *control.control, isContinuous(control.name), what)...)
+		}
+	}
+	if err := channelError(s.Transport.Channel); err != nil {
+		errs = append(errs, fmt.Errorf("transport: %w", err))
+	}
+	sceneData[transportChannelIndex] = s.Transport.Channel
+	for _, control := range s.Transport.controls() {
+		what := fmt.Sprintf("transport %s", control.name)
+		errs = append(errs, encodeControl(sceneData, transportControlIndexes[control.name], *control.control, false, what)...)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return sceneData, nil
+}
+
+const sceneHeader = `# nanoKONTROL2 scene
+# controlMode: CC | Cubase | DigitalPerformer | Live | ProTools | Sonar
+# ledMode: Internal | External
+# channel: 0-15, or 16 for the global channel
+# assign: None | ControlChange | Note, sliders and knobs can not send notes
+# behaviour: Momentary | Toggle, buttons only
+`
+
+// EncodeSceneYaml returns scene data as a human readable YAML
+func (d *KorgNanoKontrol2) EncodeSceneYaml(sceneData []byte) ([]byte, error) {
+	decodedScene, err := decodeScene(sceneData)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode scene: %w", err)
+	}
+	content, err := yaml.Marshal(decodedScene)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode scene: %w", err)
+	}
+	return append([]byte(sceneHeader), content...), nil
+}
+
+// DecodeSceneYaml returns the scene data of a YAML scene after checking it,
+// the bytes it does not set being the ones of base, or zero if base is nil
+func (d *KorgNanoKontrol2) DecodeSceneYaml(content []byte, base []byte) ([]byte, error) {
+	var decodedScene scene
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&decodedScene); err != nil {
+		return nil, fmt.Errorf("could not decode scene: %w", err)
+	}
+	return decodedScene.encode(base)
+}
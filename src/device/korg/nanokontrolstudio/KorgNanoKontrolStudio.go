@@ -0,0 +1,99 @@
+// Scene data layout based on the nanoKONTROL Studio MIDI implementation, the
+// scene LEDs being lit by the device itself
+
+package korgNanokontrolStudio
+
+import (
+	"fmt"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/fluciotto/pamixermidicontrol/src/device/korg"
+)
+
+const deviceType configuration.MidiDeviceType = "KorgNanoKontrolStudio"
+
+func init() {
+	device.Register(deviceType, func(name string) device.Driver {
+		return korg.NewSceneDriver(name, layout())
+	})
+}
+
+const (
+	groups = 8
+	// Index of the first group in the scene data, and size of a group: its
+	// channel then its controls
+	firstGroupIndex = 3
+	groupSize       = 37
+	// Index of the transport controls in the scene data, starting with their
+	// channel
+	transportIndex = firstGroupIndex + groups*groupSize
+	// Size of a control data
+	controlSize = 6
+)
+
+// Controls of a group, in scene data order
+var groupControls = []struct {
+	name       string
+	continuous bool
+}{
+	{name: "Slider", continuous: true},
+	{name: "Knob", continuous: true},
+	{name: "Solo"},
+	{name: "Mute"},
+	{name: "Record"},
+	{name: "Select"},
+}
+
+// Transport controls, in scene data order
+var transportControls = []string{
+	"Track/Prev",
+	"Track/Next",
+	"Cycle",
+	"Marker/Set",
+	"Marker/Prev",
+	"Marker/Next",
+	"Rewind",
+	"FastForward",
+	"Stop",
+	"Play",
+	"Rec",
+}
+
+func layout() korg.Layout {
+	var controls []korg.ControlLayout
+	for group := 0; group < groups; group++ {
+		groupIndex := firstGroupIndex + group*groupSize
+		for i, control := range groupControls {
+			controls = append(controls, korg.ControlLayout{
+				Path:         fmt.Sprintf("Group%d/%s", group+1, control.name),
+				ChannelIndex: groupIndex,
+				Index:        groupIndex + 1 + i*controlSize,
+				Continuous:   control.continuous,
+			})
+		}
+	}
+	for i, control := range transportControls {
+		controls = append(controls, korg.ControlLayout{
+			Path:         "Transport/" + control,
+			ChannelIndex: transportIndex,
+			Index:        transportIndex + 1 + i*controlSize,
+		})
+	}
+	// The jog wheel follows the transport buttons
+	controls = append(controls, korg.ControlLayout{
+		Path:         "JogWheel",
+		ChannelIndex: transportIndex,
+		Index:        transportIndex + 1 + len(transportControls)*controlSize,
+		Continuous:   true,
+		Relative:     true,
+	})
+	return korg.Layout{
+		DeviceType:         deviceType,
+		Name:               "Korg nanoKontrol Studio",
+		ProductID:          []byte{0x00, 0x01, 0x37, 0x01},
+		Scenes:             5,
+		GlobalChannelIndex: 0,
+		Controls:           controls,
+	}
+}
@@ -0,0 +1,349 @@
+package korg
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// Scene data layout of a Korg controller, its controls data being an assign
+// type, a behaviour, a note or controller number, and a min or off value and
+// a max or on value
+type Layout struct {
+	DeviceType configuration.MidiDeviceType
+	// Name used in logs
+	Name string
+	// Korg SysEx product ID, e.g. 0x00, 0x01, 0x13, 0x00 for nanoKONTROL2
+	ProductID []byte
+	// Number of scenes selectable on the device
+	Scenes int
+	// Index of the global MIDI channel in the scene data
+	GlobalChannelIndex int
+	Controls           []ControlLayout
+}
+
+type ControlLayout struct {
+	Path string
+	// Index of the channel of the control group in the scene data, the
+	// channel 16 meaning the global MIDI channel
+	ChannelIndex int
+	// Index of the control data
+	Index int
+	// Sliders, knobs and wheels always send control changes
+	Continuous bool
+	// Relative encoder, e.g. a jog wheel
+	Relative bool
+}
+
+// Request returns a Korg SysEx message for the product
+func Request(channel byte, productID []byte, data ...byte) []byte {
+	return slices.Concat(
+		[]byte{
+			0xf0,
+			0x42, // Korg
+			0x40 + channel&0x0f,
+		},
+		productID,
+		data,
+		[]byte{0xf7},
+	)
+}
+
+var scenePathRe = regexp.MustCompile("^Scene([1-9])/(.*)$")
+
+// SceneDriver resolves the control paths of a Korg controller from its
+// current scene data. Paths prefixed with Scene[1-n]/ are resolved only while
+// that scene is the current one.
+type SceneDriver struct {
+	log        zerolog.Logger
+	DeviceName string
+	layout     Layout
+	controls   map[string]ControlLayout
+	// Scene data captured at startup, restored on shutdown
+	startupSceneData []byte
+	// Current scene data, updated on scene changes
+	sceneData []byte
+	// Data of all the scenes, fetched once if a rule path needs them, with
+	// the fetch error
+	scenesData    [][]byte
+	scenesFetched bool
+	scenesErr     error
+	// Index of the current scene in scenesData, -1 if its data matches none
+	// of them
+	scene int
+	// Ports of the last identification, to fetch the scenes when a path
+	// needs them
+	c   chan []byte
+	out drivers.Out
+}
+
+func NewSceneDriver(name string, layout Layout) *SceneDriver {
+	return &SceneDriver{
+		log:        log.With().Str("device", layout.Name).Logger(),
+		DeviceName: name,
+		layout:     layout,
+		controls: lo.KeyBy(layout.Controls, func(control ControlLayout) string {
+			return control.Path
+		}),
+	}
+}
+
+func (d *SceneDriver) sceneDumpRequestMessage(channel byte) *device.SysExMessage {
+	request := Request(channel, d.layout.ProductID,
+		0x1f, // Data dump request
+		0x10, // Current scene data dump request
+		0x00,
+	)
+	responseHandler := func(bytes []byte) (rawData []byte, processedData []byte, err error) {
+		log := d.log.With().Str("SysEx", "sceneDumpMessage").Logger()
+		if len(bytes) < 13 || bytes[6] != 0x7f {
+			log.Error().Msgf("Scene dump request response is not a dump: % X", bytes)
+			return []byte{}, []byte{}, fmt.Errorf("scene dump request response is not a dump")
+		}
+		sceneData := MidiDataToData(bytes[12:])
+		return bytes, sceneData, nil
+	}
+	return device.NewSysExMessage(request, responseHandler)
+}
+
+func (d *SceneDriver) sceneDumpMessage(channel byte, sceneData []byte) *device.SysExMessage {
+	midiData := DataToMidiData(sceneData)
+	// Size of the data, with the dump function byte
+	size := len(midiData) + 1
+	request := Request(channel, d.layout.ProductID, slices.Concat(
+		[]byte{
+			0x7f, // Data dump command
+			0x7f,
+			0x02,
+			byte(size >> 7 & 0x7f),
+			byte(size & 0x7f),
+			0x40, // Current scene data dump
+		},
+		midiData,
+	)...)
+	responseHandler := func(bytes []byte) (rawData []byte, processedData []byte, err error) {
+		if len(bytes) < 8 {
+			return []byte{}, []byte{}, fmt.Errorf("scene dump response has a bad length: %d", len(bytes))
+		}
+		result := bytes[7] // 0x23 OK, 0x24 Error
+		d.log.Info().Msgf("Scene dump result 0x%X", result)
+		if result != 0x23 {
+			return bytes, nil, fmt.Errorf("scene dump failed with result 0x%X", result)
+		}
+		return bytes, nil, nil
+	}
+	return device.NewSysExMessage(request, responseHandler)
+}
+
+func (d *SceneDriver) sceneChangeMessage(channel byte, scene byte) *device.SysExMessage {
+	request := Request(channel, d.layout.ProductID,
+		0x1f, // Data dump request
+		0x14, // Scene change request
+		scene,
+	)
+	responseHandler := func(bytes []byte) (rawData []byte, processedData []byte, err error) {
+		if len(bytes) < 9 || bytes[7] != 0x4f {
+			return []byte{}, []byte{}, fmt.Errorf("scene change response is not a scene change: % X", bytes)
+		}
+		return bytes, []byte{bytes[8]}, nil
+	}
+	return device.NewSysExMessage(request, responseHandler)
+}
+
+func (d *SceneDriver) ControlPaths() []string {
+	paths := lo.Map(d.layout.Controls, func(control ControlLayout, i int) string {
+		return control.Path
+	})
+	if d.layout.Scenes > 1 {
+		paths = append(paths, fmt.Sprintf("Scene[1-%d]/<control path>", d.layout.Scenes))
+	}
+	return paths
+}
+
+// Identify fetches the current scene, which the control paths are resolved
+// from
+func (d *SceneDriver) Identify(c chan []byte, out drivers.Out) error {
+	if out == nil {
+		return device.ErrNoOut
+	}
+	sceneData, err := d.ReadScene(c, out)
+	if err != nil {
+		return err
+	}
+	d.startupSceneData = sceneData
+	d.sceneData = sceneData
+	d.c = c
+	d.out = out
+	return nil
+}
+
+// fetchScenes reads the data of all the scenes on its first call only, the
+// next calls returning its error. The devices can only dump their current
+// scene, so this selects each scene on the device, which is why it is done
+// once and only if a rule path needs it.
+func (d *SceneDriver) fetchScenes() error {
+	if d.out == nil {
+		return fmt.Errorf("scene not fetched")
+	}
+	if !d.scenesFetched {
+		d.scenesFetched = true
+		d.scenesData, d.scenesErr = d.readScenes(d.c, d.out)
+		d.scene = sceneIndex(d.scenesData, d.sceneData)
+	}
+	return d.scenesErr
+}
+
+// sceneIndex returns the index of the scene whose data is sceneData, -1 if
+// none matches, e.g. if the scene was edited since the scenes were fetched
+func sceneIndex(scenesData [][]byte, sceneData []byte) int {
+	return slices.IndexFunc(scenesData, func(data []byte) bool {
+		return slices.Equal(data, sceneData)
+	})
+}
+
+// readScenes reads the data of all the scenes, selecting each of them, then
+// selects back the current one, found by its data
+func (d *SceneDriver) readScenes(c chan []byte, out drivers.Out) ([][]byte, error) {
+	d.log.Info().Msgf("Fetching the %d scenes, selecting each of them", d.layout.Scenes)
+	var scenesData [][]byte
+	for scene := 0; scene < d.layout.Scenes; scene++ {
+		if _, _, err := d.sceneChangeMessage(0, byte(scene)).Send(c, out, d.log); err != nil {
+			return nil, fmt.Errorf("could not select scene %d: %w", scene+1, err)
+		}
+		sceneData, err := d.ReadScene(c, out)
+		if err != nil {
+			return nil, err
+		}
+		scenesData = append(scenesData, sceneData)
+	}
+	currentScene := sceneIndex(scenesData, d.sceneData)
+	if currentScene < 0 {
+		d.log.Warn().Msg("Could not find the current scene, selecting scene 1")
+		currentScene = 0
+	}
+	if _, _, err := d.sceneChangeMessage(0, byte(currentScene)).Send(c, out, d.log); err != nil {
+		return nil, fmt.Errorf("could not select back scene %d: %w", currentScene+1, err)
+	}
+	d.log.Info().Msgf("Fetched %d scenes, current scene %d", len(scenesData), currentScene+1)
+	return scenesData, nil
+}
+
+// SetupChanged tells whether the current scene was changed, selecting another
+// scene or editing it
+func (d *SceneDriver) SetupChanged(c chan []byte, out drivers.Out) (bool, error) {
+	sceneData, err := d.ReadScene(c, out)
+	if err != nil {
+		return false, err
+	}
+	if slices.Equal(sceneData, d.sceneData) {
+		return false, nil
+	}
+	d.log.Info().Msg("Scene changed")
+	d.sceneData = sceneData
+	if d.scenesFetched {
+		d.scene = sceneIndex(d.scenesData, sceneData)
+		if d.scene < 0 {
+			d.log.Warn().Msg("Current scene matches none of the fetched scenes, its scene rules are ignored")
+		}
+	}
+	return true, nil
+}
+
+func (d *SceneDriver) ReadScene(c chan []byte, out drivers.Out) ([]byte, error) {
+	_, sceneData, err := d.sceneDumpRequestMessage(0).Send(c, out, d.log)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch scene data: %w", err)
+	}
+	return sceneData, nil
+}
+
+func (d *SceneDriver) WriteScene(c chan []byte, out drivers.Out, sceneData []byte) error {
+	if _, _, err := d.sceneDumpMessage(0, sceneData).Send(c, out, d.log); err != nil {
+		return fmt.Errorf("could not upload scene: %w", err)
+	}
+	return nil
+}
+
+func (d *SceneDriver) Resolve(path string) (configuration.MidiMessage, error) {
+	sceneData := d.sceneData
+	if matches := scenePathRe.FindStringSubmatch(path); matches != nil {
+		scene, _ := strconv.Atoi(matches[1])
+		if scene > d.layout.Scenes {
+			return configuration.MidiMessage{}, fmt.Errorf("no scene %d on %s", scene, d.layout.DeviceType)
+		}
+		if err := d.fetchScenes(); err != nil {
+			return configuration.MidiMessage{}, err
+		}
+		if scene-1 != d.scene {
+			return configuration.MidiMessage{}, fmt.Errorf("scene %d is not the current scene: %w", scene, device.ErrInactive)
+		}
+		path = matches[2]
+	}
+	if sceneData == nil {
+		return configuration.MidiMessage{}, fmt.Errorf("scene not fetched")
+	}
+	control, ok := d.controls[path]
+	if !ok {
+		return configuration.MidiMessage{}, fmt.Errorf("no such %s control", d.layout.DeviceType)
+	}
+	if control.Index+4 >= len(sceneData) || control.ChannelIndex >= len(sceneData) {
+		return configuration.MidiMessage{}, fmt.Errorf("scene data too short for %s", path)
+	}
+	var messageType configuration.MidiMessageType
+	switch sceneData[control.Index] {
+	case 1:
+		messageType = configuration.ControlChange
+	case 2:
+		messageType = configuration.Note
+	}
+	if control.Continuous {
+		messageType = configuration.ControlChange
+	}
+	// Global MIDI channel
+	channel := sceneData[control.ChannelIndex]
+	if channel == 16 {
+		channel = sceneData[d.layout.GlobalChannelIndex]
+	}
+	message := configuration.MidiMessage{
+		Type:       messageType,
+		Channel:    configuration.ValueSet{channel},
+		Note:       configuration.ValueSet{sceneData[control.Index+2]},
+		Controller: configuration.ValueSet{sceneData[control.Index+2]},
+		MinValue:   sceneData[control.Index+3],
+		MaxValue:   sceneData[control.Index+4],
+		Relative:   control.Relative,
+	}
+	if control.Relative {
+		message.MinValue = 0x0
+		message.MaxValue = 0x7f
+	}
+	return message, nil
+}
+
+// RestoreState uploads the scene captured at startup if the current scene
+// differs from it.
+func (d *SceneDriver) RestoreState(c chan []byte, out drivers.Out) error {
+	if d.startupSceneData == nil {
+		return nil
+	}
+	sceneData, err := d.ReadScene(c, out)
+	if err != nil {
+		return err
+	}
+	if slices.Equal(sceneData, d.startupSceneData) {
+		return nil
+	}
+	d.log.Info().Msg("Restoring scene")
+	if err := d.WriteScene(c, out, d.startupSceneData); err != nil {
+		return fmt.Errorf("could not restore scene: %w", err)
+	}
+	return nil
+}
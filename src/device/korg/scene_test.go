@@ -0,0 +1,271 @@
+package korg_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/fluciotto/pamixermidicontrol/src/device/korg"
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/korg/nanokontrol"
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/korg/nanokontrolstudio"
+)
+
+// fakeDevice answers the scene dump and scene change requests of a Korg
+// controller, the SysEx responses being sent without F0 and F7 as the MIDI
+// client does
+type fakeDevice struct {
+	productID []byte
+	scenes    [][]byte
+	scene     int
+	// Number of scene change requests
+	changes int
+	c       chan []byte
+}
+
+func newFakeDevice(productID []byte, scenes ...[]byte) *fakeDevice {
+	return &fakeDevice{productID: productID, scenes: scenes, c: make(chan []byte, 1)}
+}
+
+func (d *fakeDevice) Open() error     { return nil }
+func (d *fakeDevice) Close() error    { return nil }
+func (d *fakeDevice) IsOpen() bool    { return true }
+func (d *fakeDevice) Number() int     { return 0 }
+func (d *fakeDevice) String() string  { return "fake" }
+func (d *fakeDevice) Underlying() any { return nil }
+
+func (d *fakeDevice) Send(data []byte) error {
+	if len(data) < 11 || !bytes.Equal(data[3:7], d.productID) || data[7] != 0x1f {
+		return fmt.Errorf("unexpected request % X", data)
+	}
+	header := slices.Concat([]byte{0x42, 0x40}, d.productID)
+	switch data[8] {
+	case 0x10:
+		midiData := korg.DataToMidiData(d.scenes[d.scene])
+		size := len(midiData) + 1
+		d.c <- slices.Concat(header, []byte{0x7f, 0x7f, 0x02, byte(size >> 7), byte(size & 0x7f), 0x40}, midiData)
+	case 0x14:
+		d.scene = int(data[9])
+		d.changes++
+		d.c <- slices.Concat(header, []byte{0x5f, 0x4f, data[9]})
+	default:
+		return fmt.Errorf("unexpected request % X", data)
+	}
+	return nil
+}
+
+func newDriver(t *testing.T, deviceType configuration.MidiDeviceType, fake *fakeDevice) device.Driver {
+	t.Helper()
+	driver, ok := device.New(deviceType, "test")
+	if !ok {
+		t.Fatalf("no %s driver", deviceType)
+	}
+	if err := driver.Identify(fake.c, fake); err != nil {
+		t.Fatalf("Identify failed: %s", err)
+	}
+	return driver
+}
+
+// nanoKONTROL scene data, the controls of all the groups sending control
+// changes from base on the global channel
+func nanoKontrolScene(base uint8) []byte {
+	data := make([]byte, 3+9*25+1+6*6)
+	for group := 0; group < 9; group++ {
+		groupIndex := 3 + group*25
+		data[groupIndex] = 16
+		for control := 0; control < 4; control++ {
+			index := groupIndex + 1 + control*6
+			copy(data[index:], []byte{1, 0, base + uint8(control*9+group), 0, 0x7f})
+		}
+	}
+	return data
+}
+
+func TestSceneRules(t *testing.T) {
+	scenes := [][]byte{nanoKontrolScene(0), nanoKontrolScene(10), nanoKontrolScene(20), nanoKontrolScene(30)}
+	fake := newFakeDevice([]byte{0x00, 0x01, 0x04, 0x00}, scenes...)
+	fake.scene = 1
+	driver := newDriver(t, "KorgNanoKontrol", fake)
+	watcher := driver.(device.SetupWatcher)
+	checkScenes := func(step string, want map[string]uint8) {
+		t.Helper()
+		for scene := 1; scene <= 4; scene++ {
+			path := fmt.Sprintf("Scene%d/Group1/Slider", scene)
+			message, err := driver.Resolve(path)
+			controller, active := want[path]
+			switch {
+			case active && err != nil:
+				t.Errorf("%s: Resolve(%s) failed: %s", step, path, err)
+			case active && message.Controller.First() != controller:
+				t.Errorf("%s: Resolve(%s) controller %d, want %d", step, path, message.Controller.First(), controller)
+			case !active && !errors.Is(err, device.ErrInactive):
+				t.Errorf("%s: Resolve(%s) = %v, %v, want ErrInactive", step, path, message, err)
+			}
+		}
+	}
+	checkScenes("startup", map[string]uint8{"Scene2/Group1/Slider": 10})
+	// Every scene selected once, then the current one again
+	if fake.changes != 5 || fake.scene != 1 {
+		t.Errorf("%d scene changes ending on scene %d, want 5 ending on scene 2", fake.changes, fake.scene+1)
+	}
+	// Scene 4 selected on the device
+	fake.scene = 3
+	if changed, err := watcher.SetupChanged(fake.c, fake); err != nil || !changed {
+		t.Fatalf("SetupChanged() = %t, %v, want true", changed, err)
+	}
+	checkScenes("scene change", map[string]uint8{"Scene4/Group1/Slider": 30})
+	if fake.changes != 5 {
+		t.Errorf("%d scene changes, the scenes being fetched again", fake.changes)
+	}
+	// Scene 4 edited
+	fake.scenes[3] = nanoKontrolScene(40)
+	if changed, err := watcher.SetupChanged(fake.c, fake); err != nil || !changed {
+		t.Fatalf("SetupChanged() = %t, %v, want true", changed, err)
+	}
+	checkScenes("scene edit", map[string]uint8{})
+	if message, err := driver.Resolve("Group1/Slider"); err != nil || message.Controller.First() != 40 {
+		t.Errorf("Resolve(Group1/Slider) = %v, %v, want controller 40", message, err)
+	}
+}
+
+// controlData returns the data of a control: its assign type, behaviour,
+// number, off and on values, and a reserved byte
+func controlData(assign uint8, number uint8) []byte {
+	return []byte{assign, 0, number, 0, 0x7f, 0}
+}
+
+const (
+	assignCC   = 1
+	assignNote = 2
+)
+
+// nanoKONTROL scene 1 with its factory assignments: the global channel, the
+// 9 groups on the global channel, each with its slider, knob and buttons A
+// and B, then the transport buttons
+func nanoKontrolFactoryScene() []byte {
+	data := []byte{0, 0, 0}
+	sliders := []uint8{2, 3, 4, 5, 6, 8, 9, 12, 13}
+	for group := range 9 {
+		data = append(data, 16)
+		data = slices.Concat(data,
+			controlData(assignCC, sliders[group]),
+			controlData(assignCC, 14+uint8(group)),
+			controlData(assignCC, 23+uint8(group)),
+			controlData(assignCC, 33+uint8(group)),
+		)
+	}
+	data = append(data, 16)
+	for _, number := range []uint8{47, 45, 48, 49, 46, 44} {
+		data = append(data, controlData(assignCC, number)...)
+	}
+	return data
+}
+
+// nanoKONTROL Studio scene with the global channel 16, the 8 groups on their
+// own channel, each with its slider, knob, and solo, mute, record and select
+// buttons, then the transport buttons and the jog wheel on the global channel
+func nanoKontrolStudioScene() []byte {
+	data := []byte{15, 0, 0}
+	for group := range uint8(8) {
+		data = append(data, group)
+		data = slices.Concat(data,
+			controlData(assignCC, 0x00+group),
+			controlData(assignCC, 0x10+group),
+			controlData(assignNote, 0x20+group),
+			controlData(assignNote, 0x30+group),
+			controlData(assignCC, 0x40+group),
+			controlData(assignCC, 0x50+group),
+		)
+	}
+	data = append(data, 16)
+	for button := range uint8(11) {
+		data = append(data, controlData(assignNote, 0x60+button)...)
+	}
+	return slices.Concat(data, []byte{1, 0, 0x70, 0x01, 0x41, 0})
+}
+
+func TestLayouts(t *testing.T) {
+	type control struct {
+		messageType configuration.MidiMessageType
+		channel     uint8
+		number      uint8
+		relative    bool
+	}
+	tests := []struct {
+		deviceType configuration.MidiDeviceType
+		productID  []byte
+		scene      []byte
+		controls   map[string]control
+	}{
+		{
+			deviceType: "KorgNanoKontrol",
+			productID:  []byte{0x00, 0x01, 0x04, 0x00},
+			scene:      nanoKontrolFactoryScene(),
+			controls: map[string]control{
+				"Group1/Slider":         {messageType: configuration.ControlChange, number: 2},
+				"Group9/Slider":         {messageType: configuration.ControlChange, number: 13},
+				"Group5/Knob":           {messageType: configuration.ControlChange, number: 18},
+				"Group1/ButtonA":        {messageType: configuration.ControlChange, number: 23},
+				"Group9/ButtonB":        {messageType: configuration.ControlChange, number: 41},
+				"Transport/Rewind":      {messageType: configuration.ControlChange, number: 47},
+				"Transport/Loop":        {messageType: configuration.ControlChange, number: 49},
+				"Transport/Rec":         {messageType: configuration.ControlChange, number: 44},
+				"Scene1/Group2/ButtonB": {messageType: configuration.ControlChange, number: 34},
+			},
+		},
+		{
+			deviceType: "KorgNanoKontrolStudio",
+			productID:  []byte{0x00, 0x01, 0x37, 0x01},
+			scene:      nanoKontrolStudioScene(),
+			controls: map[string]control{
+				"Group1/Slider":          {messageType: configuration.ControlChange, channel: 0, number: 0x00},
+				"Group2/Knob":            {messageType: configuration.ControlChange, channel: 1, number: 0x11},
+				"Group3/Solo":            {messageType: configuration.Note, channel: 2, number: 0x22},
+				"Group8/Mute":            {messageType: configuration.Note, channel: 7, number: 0x37},
+				"Group4/Record":          {messageType: configuration.ControlChange, channel: 3, number: 0x43},
+				"Group8/Select":          {messageType: configuration.ControlChange, channel: 7, number: 0x57},
+				"Transport/Track/Prev":   {messageType: configuration.Note, channel: 15, number: 0x60},
+				"Transport/Marker/Set":   {messageType: configuration.Note, channel: 15, number: 0x63},
+				"Transport/Rec":          {messageType: configuration.Note, channel: 15, number: 0x6a},
+				"JogWheel":               {messageType: configuration.ControlChange, channel: 15, number: 0x70, relative: true},
+				"Scene1/Group1/Solo":     {messageType: configuration.Note, channel: 0, number: 0x20},
+				"Scene1/Transport/Cycle": {messageType: configuration.Note, channel: 15, number: 0x62},
+			},
+		},
+	}
+	for _, test := range tests {
+		// Same scene in every scene slot, the current scene being the first
+		// one matching
+		var scenes [][]byte
+		for range 5 {
+			scenes = append(scenes, test.scene)
+		}
+		driver := newDriver(t, test.deviceType, newFakeDevice(test.productID, scenes...))
+		for path, want := range test.controls {
+			message, err := driver.Resolve(path)
+			if err != nil {
+				t.Errorf("%s: Resolve(%s) failed: %s", test.deviceType, path, err)
+				continue
+			}
+			number := message.Controller.First()
+			if want.messageType == configuration.Note {
+				number = message.Note.First()
+			}
+			got := control{messageType: message.Type, channel: message.Channel.First(), number: number, relative: message.Relative}
+			if got != want {
+				t.Errorf("%s: Resolve(%s) = %+v, want %+v", test.deviceType, path, got, want)
+			}
+			if want.relative && (message.MinValue != 0 || message.MaxValue != 0x7f) {
+				t.Errorf("%s: Resolve(%s) relative range %d-%d, want 0-127", test.deviceType, path, message.MinValue, message.MaxValue)
+			}
+		}
+		// The requests of another product are not answered
+		other := newFakeDevice([]byte{0x00, 0x01, 0x13, 0x00}, test.scene)
+		if driver, _ := device.New(test.deviceType, "test"); driver.Identify(other.c, other) == nil {
+			t.Errorf("%s: identified as a nanoKONTROL2", test.deviceType)
+		}
+	}
+}
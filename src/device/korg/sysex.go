@@ -19,7 +19,7 @@ func MidiDataToData(midiData []byte) []byte {
 
 func DataToMidiData(data []byte) []byte {
 	midiData := []byte{}
-	chunks := lo.Chunk(midiData, 7)
+	chunks := lo.Chunk(data, 7)
 	for _, chunk := range chunks {
 		var msbs byte
 		var lsbs []byte
@@ -0,0 +1,51 @@
+package korg
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDataToMidiData(t *testing.T) {
+	data := []byte{0x80, 0x01, 0xff, 0x7f, 0x00, 0x81, 0x02, 0x83}
+	want := []byte{
+		0x25, 0x00, 0x01, 0x7f, 0x7f, 0x00, 0x01, 0x02,
+		0x01, 0x03,
+	}
+	if got := DataToMidiData(data); !slices.Equal(got, want) {
+		t.Errorf("DataToMidiData(% X) = % X, want % X", data, got, want)
+	}
+}
+
+func TestMidiDataToData(t *testing.T) {
+	midiData := []byte{
+		0x25, 0x00, 0x01, 0x7f, 0x7f, 0x00, 0x01, 0x02,
+		0x01, 0x03,
+	}
+	want := []byte{0x80, 0x01, 0xff, 0x7f, 0x00, 0x81, 0x02, 0x83}
+	if got := MidiDataToData(midiData); !slices.Equal(got, want) {
+		t.Errorf("MidiDataToData(% X) = % X, want % X", midiData, got, want)
+	}
+}
+
+func TestDataRoundTrip(t *testing.T) {
+	// nanoKONTROL2 scene size, the last chunk being partial
+	data := make([]byte, 339)
+	for i := range data {
+		data[i] = byte(i * 37)
+	}
+	for _, length := range []int{0, 1, 6, 7, 8, 14, 339} {
+		midiData := DataToMidiData(data[:length])
+		if wantLength := length + (length+6)/7; len(midiData) != wantLength {
+			t.Errorf("length %d: encoded length %d, want %d", length, len(midiData), wantLength)
+		}
+		for _, b := range midiData {
+			if b > 0x7f {
+				t.Errorf("length %d: encoded byte 0x%X is not 7-bit", length, b)
+				break
+			}
+		}
+		if got := MidiDataToData(midiData); !slices.Equal(got, data[:length]) {
+			t.Errorf("length %d: round trip % X, want % X", length, got, data[:length])
+		}
+	}
+}
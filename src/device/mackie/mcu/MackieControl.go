@@ -0,0 +1,311 @@
+// Mackie Control Universal protocol, spoken by motorized surfaces like the
+// Behringer X-Touch, iCON Platform M+ or Presonus FaderPort 8
+
+package mackieControl
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+const deviceType configuration.MidiDeviceType = "MackieControl"
+
+func init() {
+	device.Register(deviceType, func(name string) device.Driver {
+		return New(name)
+	})
+}
+
+const (
+	strips = 8
+	// Fader channel of the master fader, strip faders being on channels 0-7
+	masterChannel = 8
+	// Channel of the buttons
+	buttonChannel = 0
+	// Fader touch notes, master fader touch being the last one
+	touchNote = 104
+	// Bank and channel buttons, shifting the strips by a bank or a strip
+	bankLeftNote     = 46
+	bankRightNote    = 47
+	channelLeftNote  = 48
+	channelRightNote = 49
+	// Highest bank offset
+	maxBankOffset = 120
+	// V-Pot controllers, sending relative ticks
+	vpotController = 16
+	// V-Pot LED ring controllers
+	ringController = 48
+	// V-Pot LED ring fan mode
+	ringFan = 0x20
+	// Characters per strip on each scribble strip line
+	lcdStripWidth = 7
+	// Offset of the scribble strip second line
+	lcdSecondLine = 0x38
+)
+
+// Strip button notes, the note of strip n being note+n
+var stripNotes = map[string]uint8{
+	"Rec":       0,
+	"Solo":      8,
+	"Mute":      16,
+	"Select":    24,
+	"VPot/Push": 32,
+}
+
+var transportNotes = map[string]uint8{
+	"Rewind":      91,
+	"FastForward": 92,
+	"Stop":        93,
+	"Play":        94,
+	"Rec":         95,
+}
+
+const functionNote = 54
+
+var (
+	stripFaderRe  = regexp.MustCompile("^Strips/Fader$")
+	stripVPotRe   = regexp.MustCompile("^Strips/VPot$")
+	stripButtonRe = regexp.MustCompile("^Strips/(Rec|Solo|Mute|Select|VPot/Push)$")
+	masterFaderRe = regexp.MustCompile("^Master/Fader$")
+	transportRe   = regexp.MustCompile("^Transport/(Rewind|FastForward|Stop|Play|Rec)$")
+	functionRe    = regexp.MustCompile("^Function([1-8])$")
+)
+
+type MackieControl struct {
+	log        zerolog.Logger
+	DeviceName string
+	// Touch and bank state, updated from the device messages
+	mutex      sync.Mutex
+	touched    [strips + 1]bool
+	bankOffset int
+}
+
+func New(name string) *MackieControl {
+	return &MackieControl{
+		log:        log.With().Str("device", "Mackie Control").Logger(),
+		DeviceName: name,
+	}
+}
+
+func (d *MackieControl) ControlPaths() []string {
+	return []string{
+		"Strips/[Fader|VPot]",
+		"Strips/VPot/Push",
+		"Strips/[Rec|Solo|Mute|Select]",
+		"Master/Fader",
+		"Transport/[Rewind|FastForward|Stop|Play|Rec]",
+		"Function[1-8]",
+	}
+}
+
+// Identify clears the scribble strips
+func (d *MackieControl) Identify(c chan []byte, out drivers.Out) error {
+	if out == nil {
+		d.log.Warn().Msg("No MIDI Out, faders, LEDs and scribble strips are not updated")
+		return nil
+	}
+	return d.clearLcd(out)
+}
+
+// RestoreState clears the scribble strips
+func (d *MackieControl) RestoreState(c chan []byte, out drivers.Out) error {
+	if out == nil {
+		return nil
+	}
+	return d.clearLcd(out)
+}
+
+func (d *MackieControl) Resolve(path string) (configuration.MidiMessage, error) {
+	message := configuration.MidiMessage{
+		MinValue: 0x0,
+		MaxValue: 0x7f,
+	}
+	switch {
+	case stripFaderRe.MatchString(path):
+		message.Type = configuration.PitchBend
+		for channel := uint8(0); channel < strips; channel++ {
+			message.Channel = append(message.Channel, channel)
+		}
+		return message, nil
+	case masterFaderRe.MatchString(path):
+		message.Type = configuration.PitchBend
+		message.Channel = configuration.ValueSet{masterChannel}
+		return message, nil
+	case stripVPotRe.MatchString(path):
+		// Ticks, 1-15 clockwise and 65-79 counterclockwise
+		message.Type = configuration.ControlChange
+		message.Channel = configuration.ValueSet{buttonChannel}
+		for strip := uint8(0); strip < strips; strip++ {
+			message.Controller = append(message.Controller, vpotController+strip)
+		}
+		message.Relative = true
+		message.RelativeEncoding = configuration.SignMagnitude
+		return message, nil
+	}
+	notes, err := buttonNotes(path)
+	if err != nil {
+		return message, err
+	}
+	message.Type = configuration.Note
+	message.Channel = configuration.ValueSet{buttonChannel}
+	message.Note = notes
+	return message, nil
+}
+
+// buttonNotes returns the notes of the buttons at path
+func buttonNotes(path string) ([]uint8, error) {
+	if matches := stripButtonRe.FindStringSubmatch(path); matches != nil {
+		var notes []uint8
+		for strip := uint8(0); strip < strips; strip++ {
+			notes = append(notes, stripNotes[matches[1]]+strip)
+		}
+		return notes, nil
+	}
+	if matches := transportRe.FindStringSubmatch(path); matches != nil {
+		return []uint8{transportNotes[matches[1]]}, nil
+	}
+	if matches := functionRe.FindStringSubmatch(path); matches != nil {
+		function, _ := strconv.Atoi(matches[1])
+		return []uint8{functionNote + uint8(function-1)}, nil
+	}
+	return nil, fmt.Errorf("no such %s control", deviceType)
+}
+
+// Input tracks the fader touches and the bank and channel buttons
+func (d *MackieControl) Input(messageType configuration.MidiMessageType, channel uint8, number uint8, value uint8) {
+	if messageType != configuration.Note || channel != buttonChannel {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if number >= touchNote && number <= touchNote+strips {
+		d.touched[number-touchNote] = value > 0
+		return
+	}
+	if value == 0 {
+		return
+	}
+	switch number {
+	case bankLeftNote:
+		d.bankOffset -= strips
+	case bankRightNote:
+		d.bankOffset += strips
+	case channelLeftNote:
+		d.bankOffset--
+	case channelRightNote:
+		d.bankOffset++
+	}
+	d.bankOffset = min(max(d.bankOffset, 0), maxBankOffset)
+}
+
+func (d *MackieControl) BankOffset() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.bankOffset
+}
+
+// Feedback moves the motor faders, unless they are touched, with the strip
+// V-Pot LED rings, and lights the button LEDs
+func (d *MackieControl) Feedback(out drivers.Out, path string, index int, value uint8) error {
+	var fader int
+	switch {
+	case stripFaderRe.MatchString(path):
+		fader = index
+	case masterFaderRe.MatchString(path):
+		fader = masterChannel
+	case stripVPotRe.MatchString(path):
+		// The LED rings follow the strip faders
+		return nil
+	default:
+		notes, err := buttonNotes(path)
+		if err != nil {
+			return err
+		}
+		if index >= len(notes) {
+			return fmt.Errorf("no control %d at %s", index, path)
+		}
+		if value == 0 {
+			return out.Send(midi.NoteOff(buttonChannel, notes[index]))
+		}
+		return out.Send(midi.NoteOn(buttonChannel, notes[index], 0x7f))
+	}
+	if fader > masterChannel {
+		return fmt.Errorf("no control %d at %s", index, path)
+	}
+	d.mutex.Lock()
+	touched := d.touched[fader]
+	d.mutex.Unlock()
+	if touched {
+		return device.ErrBusy
+	}
+	// 7-bit value spread over the 14-bit fader range
+	position := int16(value)<<7 | int16(value)
+	if err := out.Send(midi.Pitchbend(uint8(fader), position-0x2000)); err != nil {
+		return err
+	}
+	if fader == masterChannel {
+		return nil
+	}
+	ring := uint8(math.Round(float64(value) * 11 / 0x7f))
+	return out.Send(midi.ControlChange(buttonChannel, ringController+uint8(fader), ringFan|ring))
+}
+
+// Label shows the strip target name on the first scribble strip line and its
+// volume on the second one
+func (d *MackieControl) Label(out drivers.Out, path string, index int, name string, volume float32) error {
+	if !stripFaderRe.MatchString(path) || index >= strips {
+		return nil
+	}
+	volumeText := ""
+	if name != "" {
+		volumeText = fmt.Sprintf("%d%%", int(math.Round(float64(volume)*100)))
+	}
+	if err := d.writeLcd(out, index*lcdStripWidth, lcdText(name)); err != nil {
+		return err
+	}
+	return d.writeLcd(out, lcdSecondLine+index*lcdStripWidth, lcdText(volumeText))
+}
+
+// lcdText fits text to a strip, keeping a separating space
+func lcdText(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '?'
+		}
+		return r
+	}, text)
+	if len(text) > lcdStripWidth-1 {
+		text = text[:lcdStripWidth-1]
+	}
+	return fmt.Sprintf("%-*s", lcdStripWidth, text)
+}
+
+func (d *MackieControl) writeLcd(out drivers.Out, offset int, text string) error {
+	message := []byte{
+		0xf0,
+		0x00, 0x00, 0x66, // Mackie
+		0x14,               // Mackie Control
+		0x12, byte(offset), // LCD text
+	}
+	message = append(message, text...)
+	message = append(message, 0xf7)
+	return out.Send(message)
+}
+
+func (d *MackieControl) clearLcd(out drivers.Out) error {
+	if err := d.writeLcd(out, 0, strings.Repeat(" ", 2*lcdSecondLine)); err != nil {
+		return fmt.Errorf("could not clear scribble strips: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+// MIDI implementation based on the Novation Launch Control XL programmer's
+// reference guide, templates using their default control numbers
+
+package novationLaunchControlXl
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+const deviceType configuration.MidiDeviceType = "NovationLaunchControlXL"
+
+func init() {
+	device.Register(deviceType, func(name string) device.Driver {
+		return New(name)
+	})
+}
+
+const (
+	// User templates 1-8 are templates 0-7, factory templates 1-8 are
+	// templates 8-15, each template sending on the channel of its number
+	templates        = 16
+	factoryTemplates = 8
+	// Template selected at startup, factory template 1, the device not
+	// telling its template
+	defaultTemplate = factoryTemplates
+	// SysEx commands
+	templateChange = 0x77
+	setLed         = 0x78
+	// LED reset controller, value 0 turning off the LEDs of the template
+	resetController = 0
+)
+
+// Novation SysEx header, without the leading 0xf0
+var sysExHeader = []byte{
+	0x00, 0x20, 0x29, // Novation
+	0x02, 0x11, // Launch Control XL
+}
+
+// Bi-colour LED values: red and green brightness, from 0 to 3, with the
+// copy and clear flags
+const (
+	ledFlags = 0x0c
+	ledRed   = ledFlags | 0x03
+	ledGreen = ledFlags | 0x03<<4
+)
+
+type control struct {
+	messageType configuration.MidiMessageType
+	// Number of the first control of the row, or of the control
+	number uint8
+	// LED index of the first control of the row, -1 if the control has no
+	// bi-colour LED
+	led int
+}
+
+// Rows of 8 controls, the numbers of the last 4 controls of the button rows
+// following a gap
+var rows = map[string]control{
+	"SendA":   {messageType: configuration.ControlChange, number: 13, led: 0},
+	"SendB":   {messageType: configuration.ControlChange, number: 29, led: 8},
+	"Pan":     {messageType: configuration.ControlChange, number: 49, led: 16},
+	"Fader":   {messageType: configuration.ControlChange, number: 77, led: -1},
+	"Focus":   {messageType: configuration.Note, number: 41, led: 24},
+	"Control": {messageType: configuration.Note, number: 73, led: 32},
+}
+
+// Gap between the numbers of the 4th and 5th buttons of a row
+const buttonRowGap = 12
+
+var buttons = map[string]control{
+	"Device":    {messageType: configuration.Note, number: 105, led: -1},
+	"Mute":      {messageType: configuration.Note, number: 106, led: -1},
+	"Solo":      {messageType: configuration.Note, number: 107, led: -1},
+	"RecordArm": {messageType: configuration.Note, number: 108, led: -1},
+	"Up":        {messageType: configuration.ControlChange, number: 104, led: -1},
+	"Down":      {messageType: configuration.ControlChange, number: 105, led: -1},
+	"Left":      {messageType: configuration.ControlChange, number: 106, led: -1},
+	"Right":     {messageType: configuration.ControlChange, number: 107, led: -1},
+}
+
+var (
+	templateRe = regexp.MustCompile("^(?:(User|Factory)([1-8])/)?(.*)$")
+	rowRe      = regexp.MustCompile("^(SendA|SendB|Pan|Fader|Focus|Control)([1-8])$")
+	buttonRe   = regexp.MustCompile("^(Device|Mute|Solo|RecordArm|Up|Down|Left|Right)$")
+)
+
+type NovationLaunchControlXL struct {
+	log        zerolog.Logger
+	DeviceName string
+	// Current template, updated from the device messages
+	mutex    sync.Mutex
+	template int
+}
+
+func New(name string) *NovationLaunchControlXL {
+	return &NovationLaunchControlXL{
+		log:        log.With().Str("device", "Novation Launch Control XL").Logger(),
+		DeviceName: name,
+		template:   defaultTemplate,
+	}
+}
+
+func (d *NovationLaunchControlXL) ControlPaths() []string {
+	return []string{
+		"[User[1-8]/|Factory[1-8]/][SendA|SendB|Pan|Fader|Focus|Control][1-8]",
+		"[User[1-8]/|Factory[1-8]/][Device|Mute|Solo|RecordArm|Up|Down|Left|Right]",
+	}
+}
+
+func sysEx(data ...byte) []byte {
+	return slices.Concat([]byte{0xf0}, sysExHeader, data, []byte{0xf7})
+}
+
+// Identify selects the default template, the device not telling its
+// template
+func (d *NovationLaunchControlXL) Identify(c chan []byte, out drivers.Out) error {
+	if out == nil {
+		d.log.Warn().Msg("No MIDI Out, assuming factory template 1, LEDs are not updated")
+		return nil
+	}
+	if err := out.Send(sysEx(templateChange, defaultTemplate)); err != nil {
+		return fmt.Errorf("could not select template: %w", err)
+	}
+	d.log.Info().Msgf("Selected template %s", templateName(defaultTemplate))
+	return nil
+}
+
+// RestoreState turns off the LEDs of all the templates
+func (d *NovationLaunchControlXL) RestoreState(c chan []byte, out drivers.Out) error {
+	if out == nil {
+		return nil
+	}
+	for template := uint8(0); template < templates; template++ {
+		if err := out.Send(midi.ControlChange(template, resetController, 0)); err != nil {
+			return fmt.Errorf("could not reset LEDs: %w", err)
+		}
+	}
+	return nil
+}
+
+func templateName(template int) string {
+	if template < factoryTemplates {
+		return fmt.Sprintf("User%d", template+1)
+	}
+	return fmt.Sprintf("Factory%d", template-factoryTemplates+1)
+}
+
+// controlTemplates returns the templates of a control path, all of them if
+// it has none, and the path without its template
+func controlTemplates(path string) ([]uint8, string) {
+	matches := templateRe.FindStringSubmatch(path)
+	if matches[1] == "" {
+		var all []uint8
+		for template := uint8(0); template < templates; template++ {
+			all = append(all, template)
+		}
+		return all, path
+	}
+	template, _ := strconv.Atoi(matches[2])
+	template--
+	if matches[1] == "Factory" {
+		template += factoryTemplates
+	}
+	return []uint8{uint8(template)}, matches[3]
+}
+
+// findControl returns the control at path, without template, with the
+// number and LED index of the control in its row
+func findControl(path string) (control, error) {
+	if matches := rowRe.FindStringSubmatch(path); matches != nil {
+		row := rows[matches[1]]
+		position, _ := strconv.Atoi(matches[2])
+		position--
+		row.number += uint8(position)
+		if row.messageType == configuration.Note && position >= 4 {
+			row.number += buttonRowGap
+		}
+		if row.led >= 0 {
+			row.led += position
+		}
+		return row, nil
+	}
+	if matches := buttonRe.FindStringSubmatch(path); matches != nil {
+		return buttons[matches[1]], nil
+	}
+	return control{}, fmt.Errorf("no such %s control", deviceType)
+}
+
+// Resolve returns the message of the control on the template of the path,
+// on all the templates if it has none, the template being the control index
+func (d *NovationLaunchControlXL) Resolve(path string) (configuration.MidiMessage, error) {
+	channels, path := controlTemplates(path)
+	control, err := findControl(path)
+	if err != nil {
+		return configuration.MidiMessage{}, err
+	}
+	message := configuration.MidiMessage{
+		Type:     control.messageType,
+		Channel:  channels,
+		MinValue: 0x0,
+		MaxValue: 0x7f,
+	}
+	switch control.messageType {
+	case configuration.Note:
+		message.Note = configuration.ValueSet{control.number}
+	case configuration.ControlChange:
+		message.Controller = configuration.ValueSet{control.number}
+	}
+	return message, nil
+}
+
+// SysExInput tracks the template changes
+func (d *NovationLaunchControlXL) SysExInput(sysEx []byte) bool {
+	if len(sysEx) != len(sysExHeader)+2 ||
+		!slices.Equal(sysEx[:len(sysExHeader)], sysExHeader) ||
+		sysEx[len(sysExHeader)] != templateChange {
+		return false
+	}
+	template := int(sysEx[len(sysExHeader)+1])
+	if template >= templates {
+		return false
+	}
+	d.mutex.Lock()
+	d.template = template
+	d.mutex.Unlock()
+	d.log.Debug().Msgf("Template %s", templateName(template))
+	return true
+}
+
+func (d *NovationLaunchControlXL) Layer() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.template
+}
+
+// Feedback lights the bi-colour LEDs of the current template, knobs in green
+// by volume level, buttons in red when value is on, e.g. when their target
+// is muted, in green otherwise. Controls of the other templates are updated
+// when their template is selected.
+func (d *NovationLaunchControlXL) Feedback(out drivers.Out, path string, index int, value uint8) error {
+	channels, path := controlTemplates(path)
+	if index >= len(channels) {
+		return fmt.Errorf("no control %d at %s", index, path)
+	}
+	control, err := findControl(path)
+	if err != nil {
+		return err
+	}
+	if control.led < 0 {
+		return nil
+	}
+	template := channels[index]
+	if int(template) != d.Layer() {
+		return device.ErrBusy
+	}
+	var color uint8
+	switch {
+	case control.messageType == configuration.ControlChange:
+		// Volume as green brightness, from off to full
+		color = ledFlags | uint8((int(value)*3+0x7e)/0x7f)<<4
+	case value > 0:
+		color = ledRed
+	default:
+		color = ledGreen
+	}
+	return out.Send(sysEx(setLed, template, uint8(control.led), color))
+}
@@ -1,6 +1,9 @@
 package device
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/rs/zerolog"
 	"gitlab.com/gomidi/midi/v2"
 	"gitlab.com/gomidi/midi/v2/drivers"
@@ -30,6 +33,9 @@ func NewSysExMessage(
 	}
 }
 
+// Time to wait for a SysEx response from a device
+const responseTimeout = 2 * time.Second
+
 func (d *SysExMessage) Send(
 	c chan []byte, out drivers.Out,
 	log zerolog.Logger,
@@ -37,13 +43,25 @@ func (d *SysExMessage) Send(
 	theLog := log.With().Str("module", "SysExMessage").Logger()
 	send, err := midi.SendTo(out)
 	if err != nil {
-		panic(err)
+		return nil, nil, fmt.Errorf("could not send to %s: %w", out, err)
+	}
+	// Drop any unsolicited SysEx message received before the request
+	select {
+	case <-c:
+	default:
 	}
 	request := d.Request
 	theLog.Debug().Msgf("Sending SysEx message: % X", request)
 	if err = send(request); err != nil {
-		panic(err)
+		return nil, nil, fmt.Errorf("could not send SysEx message % X: %w", request, err)
+	}
+	if d.ResponseHandler == nil {
+		return nil, nil, nil
+	}
+	select {
+	case response := <-c:
+		return d.ResponseHandler(response)
+	case <-time.After(responseTimeout):
+		return nil, nil, fmt.Errorf("no response to SysEx message % X after %s", request, responseTimeout)
 	}
-	response := <-c
-	return d.ResponseHandler(response)
 }
@@ -0,0 +1,171 @@
+package midi
+
+import (
+	"math"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+)
+
+// normalizeValue maps a MIDI value from the message value range onto 0..1
+func normalizeValue(midiMessage configuration.MidiMessage, value uint8) float32 {
+	minValue := midiMessage.MinValue
+	maxValue := midiMessage.MaxValue
+	if maxValue == 0 {
+		maxValue = 0x7f
+	}
+	if maxValue <= minValue {
+		return 0
+	}
+	value = min(max(value, minValue), maxValue)
+	return float32(value-minValue) / float32(maxValue-minValue)
+}
+
+// denormalizeValue maps 0..1 onto the message value range
+func denormalizeValue(midiMessage configuration.MidiMessage, value float32) uint8 {
+	minValue := midiMessage.MinValue
+	maxValue := midiMessage.MaxValue
+	if maxValue == 0 {
+		maxValue = 0x7f
+	}
+	if maxValue <= minValue {
+		return minValue
+	}
+	value = min(max(value, 0), 1)
+	return minValue + uint8(math.Round(float64(value)*float64(maxValue-minValue)))
+}
+
+// Volume step of a relative control tick, as a fraction of the action volume
+// range
+const relativeVolumeStep = 0.01
+
+// relativeDelta decodes the ticks of a relative control value, 7-bit two's
+// complement or sign magnitude
+func relativeDelta(midiMessage configuration.MidiMessage, value uint8) int {
+	if midiMessage.RelativeEncoding == configuration.SignMagnitude {
+		if value&0x40 != 0 {
+			return -int(value & 0x3f)
+		}
+		return int(value & 0x3f)
+	}
+	if value >= 0x40 {
+		return int(value) - 0x80
+	}
+	return int(value)
+}
+
+// withIndex resolves the action target for the index of the triggering
+// control among the rule ones, returns false if there is no such target
+func withIndex(action configuration.Action, index int) (configuration.Action, bool) {
+	switch target := action.Target.(type) {
+	case *configuration.TypedTarget:
+		resolved := *target
+		resolved.Index = index
+		if len(target.Names) > 0 {
+			if index >= len(target.Names) {
+				return action, false
+			}
+			resolved.Name = target.Names[index]
+		}
+		action.Target = &resolved
+	case *configuration.Target:
+		resolved := *target
+		resolved.Index = index
+		if len(target.Names) > 0 {
+			if index >= len(target.Names) {
+				return action, false
+			}
+			resolved.Name = target.Names[index]
+		}
+		action.Target = &resolved
+	}
+	return action, true
+}
+
+func (client *MidiClient) doActions(rule configuration.Rule, value uint8, index int) {
+	// ToggleMute actions are processed together, on the first one
+	mutesToggled := false
+	for _, action := range rule.Actions {
+		action, ok := withIndex(action, index)
+		if !ok {
+			client.log.Debug().Msgf("No target at index %d for action %s", index, action.Type)
+			continue
+		}
+		switch action.Type {
+		case configuration.SetVolume:
+			minVolume, maxVolume := action.VolumeRange()
+			if rule.MidiMessage.Relative {
+				step := float32(relativeDelta(rule.MidiMessage, value)) * relativeVolumeStep * (maxVolume - minVolume)
+				if err := client.PAClient.StepVolume(action, step); err != nil {
+					client.log.Error().Err(err).Msgf("Could not step volume")
+				}
+				continue
+			}
+			volumePercent := minVolume + normalizeValue(rule.MidiMessage, value)*(maxVolume-minVolume)
+			if err := client.PAClient.ProcessVolumeAction(action, volumePercent); err != nil {
+				client.log.Error().Err(err).Msgf("Could not set volume")
+			}
+		case configuration.SetGroupVolume:
+			minVolume, maxVolume := action.VolumeRange()
+			volume := minVolume + normalizeValue(rule.MidiMessage, value)*(maxVolume-minVolume)
+			if err := client.PAClient.ProcessGroupVolume(action, volume); err != nil {
+				client.log.Error().Err(err).Msgf("Could not set group volume")
+			}
+		case configuration.ToggleMute:
+			if value == 0 {
+				return
+			}
+			if mutesToggled {
+				continue
+			}
+			mutesToggled = true
+			var actions []configuration.Action
+			for _, action := range rule.Actions {
+				if action, ok := withIndex(action, index); ok && action.Type == configuration.ToggleMute {
+					actions = append(actions, action)
+				}
+			}
+			if err := client.PAClient.ProcessToggleMute(actions, rule.MutePolicy); err != nil {
+				client.log.Error().Err(err).Msgf("Could not toggle mute")
+			}
+		case configuration.Solo:
+			if value == 0 {
+				return
+			}
+			if err := client.PAClient.ProcessSolo(action); err != nil {
+				client.log.Error().Err(err).Msgf("Could not solo")
+			}
+		case configuration.FadeVolume:
+			if value == 0 {
+				return
+			}
+			if err := client.PAClient.ProcessFadeVolume(action); err != nil {
+				client.log.Error().Err(err).Msgf("Could not fade volume")
+			}
+		case configuration.SetDefaultOutput:
+			if value == 0 {
+				return
+			}
+			if err := client.PAClient.SetDefaultOutput(action); err != nil {
+				client.log.Error().Err(err).Msgf("Could not set default output")
+			}
+		case configuration.FocusNext, configuration.FocusPrevious:
+			if value == 0 {
+				return
+			}
+			step := 1
+			if action.Type == configuration.FocusPrevious {
+				step = -1
+			}
+			// Relative controls move the focus backwards when turned
+			// counterclockwise
+			if rule.MidiMessage.Relative && relativeDelta(rule.MidiMessage, value) < 0 {
+				step = -step
+			}
+			if err := client.PAClient.MoveFocus(action, step); err != nil {
+				client.log.Error().Err(err).Msgf("Could not move focus")
+			}
+		default:
+			client.log.Error().Msgf("Unknown action type %s in rule %+v", action.Type, rule)
+		}
+	}
+}
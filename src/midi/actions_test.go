@@ -0,0 +1,111 @@
+package midi
+
+import (
+	"testing"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+)
+
+func TestNormalizeValue(t *testing.T) {
+	tests := []struct {
+		minValue uint8
+		maxValue uint8
+		value    uint8
+		want     float32
+	}{
+		{minValue: 0, maxValue: 0x7f, value: 0, want: 0},
+		{minValue: 0, maxValue: 0x7f, value: 0x7f, want: 1},
+		// maxValue 0 means 0x7f
+		{minValue: 0, maxValue: 0, value: 0x7f, want: 1},
+		{minValue: 0x1f, maxValue: 0, value: 0x4f, want: 0.5},
+		// minValue offset
+		{minValue: 0x10, maxValue: 0x30, value: 0x10, want: 0},
+		{minValue: 0x10, maxValue: 0x30, value: 0x20, want: 0.5},
+		{minValue: 0x10, maxValue: 0x30, value: 0x30, want: 1},
+		// Values out of the range are clamped
+		{minValue: 0x10, maxValue: 0x30, value: 0x05, want: 0},
+		{minValue: 0x10, maxValue: 0x30, value: 0x7f, want: 1},
+		// Empty or reversed range
+		{minValue: 0x40, maxValue: 0x40, value: 0x40, want: 0},
+		{minValue: 0x40, maxValue: 0x20, value: 0x30, want: 0},
+	}
+	for _, test := range tests {
+		midiMessage := configuration.MidiMessage{MinValue: test.minValue, MaxValue: test.maxValue}
+		if got := normalizeValue(midiMessage, test.value); got != test.want {
+			t.Errorf("normalizeValue(%d-%d, %d) = %f, want %f", test.minValue, test.maxValue, test.value, got, test.want)
+		}
+	}
+}
+
+func TestDenormalizeValue(t *testing.T) {
+	tests := []struct {
+		minValue uint8
+		maxValue uint8
+		value    float32
+		want     uint8
+	}{
+		{minValue: 0, maxValue: 0x7f, value: 0, want: 0},
+		{minValue: 0, maxValue: 0x7f, value: 1, want: 0x7f},
+		// maxValue 0 means 0x7f
+		{minValue: 0, maxValue: 0, value: 1, want: 0x7f},
+		{minValue: 0x1f, maxValue: 0, value: 0.5, want: 0x4f},
+		// minValue offset
+		{minValue: 0x10, maxValue: 0x30, value: 0, want: 0x10},
+		{minValue: 0x10, maxValue: 0x30, value: 0.5, want: 0x20},
+		{minValue: 0x10, maxValue: 0x30, value: 1, want: 0x30},
+		// Values out of 0..1 are clamped
+		{minValue: 0x10, maxValue: 0x30, value: -0.5, want: 0x10},
+		{minValue: 0x10, maxValue: 0x30, value: 1.5, want: 0x30},
+		// Empty or reversed range
+		{minValue: 0x40, maxValue: 0x40, value: 1, want: 0x40},
+		{minValue: 0x40, maxValue: 0x20, value: 1, want: 0x40},
+	}
+	for _, test := range tests {
+		midiMessage := configuration.MidiMessage{MinValue: test.minValue, MaxValue: test.maxValue}
+		if got := denormalizeValue(midiMessage, test.value); got != test.want {
+			t.Errorf("denormalizeValue(%d-%d, %f) = %d, want %d", test.minValue, test.maxValue, test.value, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeRoundTrip(t *testing.T) {
+	for _, midiMessage := range []configuration.MidiMessage{
+		{MinValue: 0, MaxValue: 0},
+		{MinValue: 0x10, MaxValue: 0x30},
+		{MinValue: 0x7e, MaxValue: 0x7f},
+	} {
+		maxValue := midiMessage.MaxValue
+		if maxValue == 0 {
+			maxValue = 0x7f
+		}
+		for value := midiMessage.MinValue; value <= maxValue; value++ {
+			if got := denormalizeValue(midiMessage, normalizeValue(midiMessage, value)); got != value {
+				t.Errorf("%d-%d: round trip of %d = %d", midiMessage.MinValue, midiMessage.MaxValue, value, got)
+			}
+		}
+	}
+}
+
+func TestRelativeDelta(t *testing.T) {
+	tests := []struct {
+		encoding configuration.RelativeEncoding
+		value    uint8
+		want     int
+	}{
+		{encoding: "", value: 0x01, want: 1},
+		{encoding: "", value: 0x3f, want: 63},
+		{encoding: "", value: 0x7f, want: -1},
+		{encoding: "", value: 0x40, want: -64},
+		{encoding: configuration.TwosComplement, value: 0x7e, want: -2},
+		{encoding: configuration.SignMagnitude, value: 0x01, want: 1},
+		{encoding: configuration.SignMagnitude, value: 0x0f, want: 15},
+		{encoding: configuration.SignMagnitude, value: 0x41, want: -1},
+		{encoding: configuration.SignMagnitude, value: 0x4f, want: -15},
+	}
+	for _, test := range tests {
+		midiMessage := configuration.MidiMessage{Relative: true, RelativeEncoding: test.encoding}
+		if got := relativeDelta(midiMessage, test.value); got != test.want {
+			t.Errorf("relativeDelta(%q, 0x%02x) = %d, want %d", test.encoding, test.value, got, test.want)
+		}
+	}
+}
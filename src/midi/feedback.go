@@ -0,0 +1,142 @@
+package midi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/fluciotto/pamixermidicontrol/src/pulseaudio"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// feedbackAction returns the first volume or mute action of a rule with a
+// stream target for a control index, false if there is none
+func feedbackAction(rule configuration.Rule, index int) (configuration.Action, bool) {
+	for _, action := range rule.Actions {
+		action, ok := withIndex(action, index)
+		if !ok {
+			continue
+		}
+		if _, ok := action.Target.(*configuration.TypedTarget); ok && (action.Type == configuration.SetVolume || action.Type == configuration.ToggleMute) {
+			return action, true
+		}
+	}
+	return configuration.Action{}, false
+}
+
+// feedbackValue returns the value showing the target state of a feedback
+// action, 0 if the target has no stream, false if it can not be shown
+func feedbackValue(midiMessage configuration.MidiMessage, action configuration.Action, state *pulseaudio.StreamState) (uint8, bool) {
+	if state == nil {
+		return 0, true
+	}
+	if action.Type == configuration.ToggleMute {
+		if state.Muted {
+			return 0x7f, true
+		}
+		return 0, true
+	}
+	minVolume, maxVolume := action.VolumeRange()
+	if maxVolume <= minVolume {
+		return 0, false
+	}
+	return denormalizeValue(midiMessage, (state.Volume-minVolume)/(maxVolume-minVolume)), true
+}
+
+// Control showing the target state of a feedback action
+type feedbackControl struct {
+	path        string
+	index       int
+	midiMessage configuration.MidiMessage
+	action      configuration.Action
+}
+
+// runFeedback shows the state of the rule targets on the controls of the
+// device on PulseAudio, bank, layer and rules changes, until ctx is
+// cancelled
+func (client *MidiClient) runFeedback(ctx context.Context, driver device.FeedbackDriver, out drivers.Out) {
+	labelDriver, hasLabels := driver.(device.LabelDriver)
+	sentValues := map[string]uint8{}
+	sentLabels := map[string]pulseaudio.StreamState{}
+	update := func() {
+		var controls []feedbackControl
+		var targets []configuration.TypedTarget
+		for _, rule := range client.resolved().rules {
+			path := rule.MidiMessage.DeviceControlPath
+			// Relative controls have no position to show
+			if path == "" || rule.MidiMessage.Relative {
+				continue
+			}
+			bankOffset := client.bankOffset(&rule)
+			for index := 0; index < controlCount(rule.MidiMessage); index++ {
+				action, ok := feedbackAction(rule, index+bankOffset)
+				if !ok {
+					continue
+				}
+				controls = append(controls, feedbackControl{path: path, index: index, midiMessage: rule.MidiMessage, action: action})
+				targets = append(targets, *action.Target.(*configuration.TypedTarget))
+			}
+		}
+		if len(controls) == 0 {
+			return
+		}
+		states, err := client.PAClient.TargetStates(targets)
+		if err != nil {
+			client.log.Debug().Msgf("Could not get target states: %s", err)
+			return
+		}
+		for i, control := range controls {
+			key := fmt.Sprintf("%s/%d", control.path, control.index)
+			value, ok := feedbackValue(control.midiMessage, control.action, states[i])
+			if !ok {
+				continue
+			}
+			var state pulseaudio.StreamState
+			if states[i] != nil {
+				state = *states[i]
+			}
+			// Labels show the volume, not the mute
+			state.Muted = false
+			if previous, ok := sentLabels[key]; hasLabels && (!ok || previous != state) {
+				if err := labelDriver.Label(out, control.path, control.index, state.Name, state.Volume); err != nil {
+					client.log.Error().Err(err).Msgf("Could not send label to %s", key)
+				} else {
+					sentLabels[key] = state
+				}
+			}
+			if previous, ok := sentValues[key]; ok && previous == value {
+				continue
+			}
+			if err := driver.Feedback(out, control.path, control.index, value); err != nil {
+				if !errors.Is(err, device.ErrBusy) {
+					client.log.Error().Err(err).Msgf("Could not send feedback to %s", key)
+				}
+				continue
+			}
+			sentValues[key] = value
+		}
+	}
+	update()
+	for {
+		select {
+		case <-client.updates:
+			update()
+		case <-client.bankChanges:
+			update()
+		case <-client.layerChanges:
+			// The device shows the controls of another layer
+			clear(sentValues)
+			clear(sentLabels)
+			update()
+		case <-client.rulesChanges:
+			// The controls may have other rules
+			clear(sentValues)
+			clear(sentLabels)
+			update()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package midi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+)
+
+// Default gesture timings
+const (
+	defaultLongPress      = 500 * time.Millisecond
+	defaultDoublePress    = 300 * time.Millisecond
+	defaultRepeatDelay    = 500 * time.Millisecond
+	defaultRepeatInterval = 100 * time.Millisecond
+)
+
+// Control sending MIDI messages
+type controlKey struct {
+	Type    configuration.MidiMessageType
+	Channel uint8
+	Number  uint8
+}
+
+// Gesture state of a button
+type buttonState struct {
+	pressed bool
+	// Number of the current press, the timers of the previous presses do
+	// nothing
+	press int
+	// A long or double press was detected since the last press, no tap on release
+	gestureDone    bool
+	longPressTimer *time.Timer
+	repeatTimer    *time.Timer
+	// Tap waiting for the double press delay
+	tapTimer *time.Timer
+}
+
+// gestureDetector turns button presses and releases into gestures
+type gestureDetector struct {
+	timings configuration.GestureTimings
+	// Whether a control has double press rules, taps are then delayed
+	hasDoublePress func(key controlKey) bool
+	onGesture      func(key controlKey, gesture configuration.Gesture)
+	mutex          sync.Mutex
+	buttons        map[controlKey]*buttonState
+}
+
+func newGestureDetector(
+	timings configuration.GestureTimings,
+	hasDoublePress func(key controlKey) bool,
+	onGesture func(key controlKey, gesture configuration.Gesture),
+) *gestureDetector {
+	if timings.LongPress == 0 {
+		timings.LongPress = defaultLongPress
+	}
+	if timings.DoublePress == 0 {
+		timings.DoublePress = defaultDoublePress
+	}
+	if timings.RepeatDelay == 0 {
+		timings.RepeatDelay = defaultRepeatDelay
+	}
+	if timings.RepeatInterval == 0 {
+		timings.RepeatInterval = defaultRepeatInterval
+	}
+	return &gestureDetector{
+		timings:        timings,
+		hasDoublePress: hasDoublePress,
+		onGesture:      onGesture,
+		buttons:        map[controlKey]*buttonState{},
+	}
+}
+
+// handle processes a button state change, non zero values are presses
+func (detector *gestureDetector) handle(key controlKey, value uint8) {
+	detector.emit(key, detector.update(key, value != 0))
+}
+
+func (detector *gestureDetector) emit(key controlKey, gestures []configuration.Gesture) {
+	for _, gesture := range gestures {
+		detector.onGesture(key, gesture)
+	}
+}
+
+// update updates the button state and returns the detected gestures
+func (detector *gestureDetector) update(key controlKey, pressed bool) []configuration.Gesture {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	return detector.change(key, pressed)
+}
+
+// change updates the button state and returns the detected gestures. The
+// detector lock must be held.
+func (detector *gestureDetector) change(key controlKey, pressed bool) (gestures []configuration.Gesture) {
+	button, ok := detector.buttons[key]
+	if !ok {
+		button = &buttonState{}
+		detector.buttons[key] = button
+	}
+	if pressed == button.pressed {
+		return nil
+	}
+	button.pressed = pressed
+	if pressed {
+		gestures = append(gestures, configuration.Press)
+		button.press++
+		press := button.press
+		// Timers of the previous press, which may have fired already
+		button.stopTimers()
+		button.gestureDone = false
+		if button.tapTimer != nil && button.tapTimer.Stop() {
+			button.tapTimer = nil
+			button.gestureDone = true
+			gestures = append(gestures, configuration.DoublePress)
+		}
+		button.longPressTimer = time.AfterFunc(detector.timings.LongPress, func() {
+			detector.emit(key, detector.locked(func() []configuration.Gesture {
+				if button.press != press || !button.pressed || button.gestureDone {
+					return nil
+				}
+				button.gestureDone = true
+				return []configuration.Gesture{configuration.LongPress}
+			}))
+		})
+		gestures = append(gestures, configuration.HoldRepeat)
+		var repeat func()
+		repeat = func() {
+			detector.emit(key, detector.locked(func() []configuration.Gesture {
+				if button.press != press || !button.pressed {
+					return nil
+				}
+				button.repeatTimer = time.AfterFunc(detector.timings.RepeatInterval, repeat)
+				return []configuration.Gesture{configuration.HoldRepeat}
+			}))
+		}
+		button.repeatTimer = time.AfterFunc(detector.timings.RepeatDelay, repeat)
+	} else {
+		gestures = append(gestures, configuration.Release)
+		button.stopTimers()
+		if button.gestureDone {
+			return gestures
+		}
+		if detector.hasDoublePress(key) {
+			button.tapTimer = time.AfterFunc(detector.timings.DoublePress, func() {
+				detector.emit(key, detector.locked(func() []configuration.Gesture {
+					button.tapTimer = nil
+					return []configuration.Gesture{configuration.Tap}
+				}))
+			})
+		} else {
+			gestures = append(gestures, configuration.Tap)
+		}
+	}
+	return gestures
+}
+
+// stopTimers stops the long press and hold repeat timers of a press
+func (button *buttonState) stopTimers() {
+	if button.longPressTimer != nil {
+		button.longPressTimer.Stop()
+	}
+	if button.repeatTimer != nil {
+		button.repeatTimer.Stop()
+	}
+}
+
+// locked runs a timer callback with the detector lock held
+func (detector *gestureDetector) locked(fn func() []configuration.Gesture) []configuration.Gesture {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	return fn()
+}
@@ -0,0 +1,148 @@
+package midi
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+)
+
+var testTimings = configuration.GestureTimings{
+	LongPress:      60 * time.Millisecond,
+	DoublePress:    60 * time.Millisecond,
+	RepeatDelay:    60 * time.Millisecond,
+	RepeatInterval: 20 * time.Millisecond,
+}
+
+// Gestures detected on a test button
+type gestureRecorder struct {
+	mutex    sync.Mutex
+	gestures []configuration.Gesture
+}
+
+func (recorder *gestureRecorder) record(key controlKey, gesture configuration.Gesture) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.gestures = append(recorder.gestures, gesture)
+}
+
+// take returns the gestures detected since the last call
+func (recorder *gestureRecorder) take() []configuration.Gesture {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	gestures := recorder.gestures
+	recorder.gestures = nil
+	return gestures
+}
+
+func newTestDetector(doublePress bool) (*gestureDetector, *gestureRecorder) {
+	recorder := &gestureRecorder{}
+	detector := newGestureDetector(testTimings, func(key controlKey) bool {
+		return doublePress
+	}, recorder.record)
+	return detector, recorder
+}
+
+func countGestures(gestures []configuration.Gesture, gesture configuration.Gesture) int {
+	n := 0
+	for _, g := range gestures {
+		if g == gesture {
+			n++
+		}
+	}
+	return n
+}
+
+var testKey = controlKey{Type: configuration.Note, Number: 36}
+
+func TestTap(t *testing.T) {
+	detector, recorder := newTestDetector(false)
+	detector.handle(testKey, 0x7f)
+	detector.handle(testKey, 0)
+	want := []configuration.Gesture{configuration.Press, configuration.HoldRepeat, configuration.Release, configuration.Tap}
+	if got := recorder.take(); !slices.Equal(got, want) {
+		t.Errorf("gestures %v, want %v", got, want)
+	}
+	// No timer gesture after the release
+	time.Sleep(2 * testTimings.LongPress)
+	if got := recorder.take(); len(got) != 0 {
+		t.Errorf("gestures %v after the release, want none", got)
+	}
+}
+
+func TestDelayedTap(t *testing.T) {
+	detector, recorder := newTestDetector(true)
+	detector.handle(testKey, 0x7f)
+	detector.handle(testKey, 0)
+	if got := recorder.take(); slices.Contains(got, configuration.Tap) {
+		t.Errorf("gestures %v, want the tap delayed", got)
+	}
+	time.Sleep(2 * testTimings.DoublePress)
+	if got := recorder.take(); !slices.Equal(got, []configuration.Gesture{configuration.Tap}) {
+		t.Errorf("gestures %v after the double press delay, want a tap", got)
+	}
+}
+
+func TestDoublePress(t *testing.T) {
+	detector, recorder := newTestDetector(true)
+	detector.handle(testKey, 0x7f)
+	detector.handle(testKey, 0)
+	detector.handle(testKey, 0x7f)
+	detector.handle(testKey, 0)
+	time.Sleep(2 * testTimings.DoublePress)
+	got := recorder.take()
+	if countGestures(got, configuration.DoublePress) != 1 || countGestures(got, configuration.Tap) != 0 {
+		t.Errorf("gestures %v, want a double press and no tap", got)
+	}
+}
+
+func TestLongPress(t *testing.T) {
+	detector, recorder := newTestDetector(false)
+	detector.handle(testKey, 0x7f)
+	time.Sleep(testTimings.LongPress + testTimings.LongPress/2)
+	detector.handle(testKey, 0)
+	got := recorder.take()
+	if countGestures(got, configuration.LongPress) != 1 || countGestures(got, configuration.Tap) != 0 {
+		t.Errorf("gestures %v, want a long press and no tap", got)
+	}
+}
+
+func TestHoldRepeat(t *testing.T) {
+	detector, recorder := newTestDetector(false)
+	detector.handle(testKey, 0x7f)
+	// The press, then the first repeat after the delay and 3 more repeats
+	time.Sleep(testTimings.RepeatDelay + 3*testTimings.RepeatInterval + testTimings.RepeatInterval/2)
+	detector.handle(testKey, 0)
+	if got := countGestures(recorder.take(), configuration.HoldRepeat); got < 4 || got > 6 {
+		t.Errorf("%d hold repeats, want 5", got)
+	}
+	time.Sleep(3 * testTimings.RepeatInterval)
+	if got := recorder.take(); len(got) != 0 {
+		t.Errorf("gestures %v after the release, want none", got)
+	}
+}
+
+func TestRepressDuringRepeat(t *testing.T) {
+	detector, recorder := newTestDetector(false)
+	detector.handle(testKey, 0x7f)
+	// The first repeat timer fires while the button is released and
+	// pressed again, and waits for the lock
+	detector.mutex.Lock()
+	time.Sleep(testTimings.RepeatDelay + testTimings.RepeatInterval/2)
+	detector.change(testKey, false)
+	detector.change(testKey, true)
+	recorder.take()
+	detector.mutex.Unlock()
+	// Only the repeats of the new press, after the repeat delay
+	time.Sleep(testTimings.RepeatDelay / 2)
+	if got := recorder.take(); len(got) != 0 {
+		t.Errorf("gestures %v before the repeat delay of the new press, want none", got)
+	}
+	time.Sleep(testTimings.RepeatDelay/2 + 4*testTimings.RepeatInterval + testTimings.RepeatInterval/2)
+	detector.handle(testKey, 0)
+	if got := countGestures(recorder.take(), configuration.HoldRepeat); got < 4 || got > 6 {
+		t.Errorf("%d hold repeats, want 5 from a single repeat chain", got)
+	}
+}
@@ -1,22 +1,34 @@
 package midi
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/fluciotto/pamixermidicontrol/src/configuration"
-	akaiLpd8 "github.com/fluciotto/pamixermidicontrol/src/device/akai/lpd8"
-	korgNanokontrol2 "github.com/fluciotto/pamixermidicontrol/src/device/korg/nanokontrol2"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
 	"github.com/fluciotto/pamixermidicontrol/src/pulseaudio"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/samber/lo"
 	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
 
 	driver "gitlab.com/gomidi/midi/v2/drivers/portmididrv"
 )
 
+// Delays between two attempts to run a device
+const (
+	minRetryDelay = 1 * time.Second
+	maxRetryDelay = 1 * time.Minute
+)
+
 func listDevices() ([]string, []string, error) {
 	drv, err := driver.New()
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 	// make sure to close all open ports at the end
 	defer drv.Close()
@@ -42,11 +54,11 @@ func listDevices() ([]string, []string, error) {
 	return inNames, outNames, nil
 }
 
-func List() {
+func List() error {
 	log := log.Logger.With().Str("module", "Midi").Logger()
 	ins, outs, err := listDevices()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("could not list MIDI ports: %w", err)
 	}
 	// List input ports
 	for _, port := range ins {
@@ -56,6 +68,7 @@ func List() {
 	for _, port := range outs {
 		log.Info().Msgf("Found midi out device:\t%s", port)
 	}
+	return nil
 }
 
 type MidiClient struct {
@@ -63,160 +76,281 @@ type MidiClient struct {
 	PAClient   *pulseaudio.PAClient
 	MidiDevice configuration.MidiDevice
 	Rules      []configuration.Rule
+	// Rules with device control paths resolved, guarded by mutex
+	mutex         sync.Mutex
+	resolvedRules *resolvedRules
+	gestures      *gestureDetector
+	// PulseAudio changes, to update the device feedback
+	updates <-chan struct{}
+	// Device bank changes, to update the device feedback
+	bankChanges chan struct{}
+	// Device layer changes, to send the device feedback again
+	layerChanges chan struct{}
+	// Rules resolved again on device setup changes, to send the device
+	// feedback again
+	rulesChanges chan struct{}
+	// Configured program written and selected, once per process
+	programWritten bool
+	// Driver of the first identification, holding the device state captured
+	// at startup, restored on shutdown
+	startupDriver device.Driver
 }
 
 func NewMidiClient(paClient *pulseaudio.PAClient, device configuration.MidiDevice, rules []configuration.Rule) *MidiClient {
 	client := &MidiClient{
-		log:        log.With().Str("module", "Midi").Str("device", device.Name).Logger(),
-		PAClient:   paClient,
-		MidiDevice: device,
-		Rules:      rules,
+		log:          log.With().Str("module", "Midi").Str("device", device.Name).Logger(),
+		PAClient:     paClient,
+		MidiDevice:   device,
+		Rules:        rules,
+		updates:      paClient.Subscribe(),
+		bankChanges:  make(chan struct{}, 1),
+		layerChanges: make(chan struct{}, 1),
+		rulesChanges: make(chan struct{}, 1),
 	}
+	client.setRules(nil, nil)
+	client.gestures = newGestureDetector(device.Gestures, client.hasDoublePress, client.onGesture)
 	return client
 }
 
-func (client *MidiClient) Run() {
+// trackChanges runs f, passing a message to the device driver, and signals
+// the bank and layer changes it made
+func (client *MidiClient) trackChanges(driver device.Driver, f func()) {
+	bankDriver, hasBanks := driver.(device.BankDriver)
+	layerDriver, hasLayers := driver.(device.LayerDriver)
+	var bankOffset, layer int
+	if hasBanks {
+		bankOffset = bankDriver.BankOffset()
+	}
+	if hasLayers {
+		layer = layerDriver.Layer()
+	}
+	f()
+	if hasBanks && bankDriver.BankOffset() != bankOffset {
+		client.log.Info().Msgf("Bank offset %d", bankDriver.BankOffset())
+		select {
+		case client.bankChanges <- struct{}{}:
+		default:
+		}
+	}
+	if hasLayers && layerDriver.Layer() != layer {
+		client.log.Info().Msgf("Layer %d", layerDriver.Layer())
+		select {
+		case client.layerChanges <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// input handles a message received from the device, passing it to the device
+// driver before the rules
+func (client *MidiClient) input(messageType configuration.MidiMessageType, channel uint8, number uint8, value uint8) {
+	if inputDriver, ok := client.resolved().driver.(device.InputDriver); ok {
+		client.trackChanges(inputDriver, func() {
+			inputDriver.Input(messageType, channel, number, value)
+		})
+	}
+	client.dispatch(messageType, channel, number, value)
+}
+
+// sysExInput passes a SysEx message received from the device to the device
+// driver, false if the driver did not handle it
+func (client *MidiClient) sysExInput(sysEx []byte) bool {
+	sysExDriver, ok := client.resolved().driver.(device.SysExInputDriver)
+	if !ok {
+		return false
+	}
+	handled := false
+	client.trackChanges(sysExDriver, func() {
+		handled = sysExDriver.SysExInput(sysEx)
+	})
+	return handled
+}
+
+// Run runs the device until it fails, then retries with an increasing delay,
+// so that a failing device does not affect the other ones. It returns when
+// ctx is cancelled.
+func (client *MidiClient) Run(ctx context.Context) {
+	retryDelay := minRetryDelay
+	for {
+		startTime := time.Now()
+		err := client.run(ctx)
+		if ctx.Err() != nil {
+			client.log.Info().Msg("Stopped")
+			return
+		}
+		if time.Since(startTime) > maxRetryDelay {
+			retryDelay = minRetryDelay
+		}
+		client.log.Error().Err(err).Msgf("Device failed, retrying in %s", retryDelay)
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			client.log.Info().Msg("Stopped")
+			return
+		}
+		retryDelay = min(2*retryDelay, maxRetryDelay)
+	}
+}
+
+func (client *MidiClient) run(ctx context.Context) error {
 	drv, err := driver.New()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("could not initialize MIDI driver: %w", err)
 	}
 
 	// make sure to close all open ports at the end
 	defer drv.Close()
 
-	in, err := midi.FindInPort(client.MidiDevice.MidiInName)
-	if err != nil {
-		// panic(err)
-		client.log.Error().Msgf("Could not find MIDI In %s", client.MidiDevice.MidiInName)
-	}
-
-	out, err := midi.FindOutPort(client.MidiDevice.MidiOutName)
+	in, err := findInPort(client.MidiDevice.MidiInName, client.MidiDevice.MidiPortIndex)
 	if err != nil {
-		// panic(err)
-		client.log.Error().Msgf("Could not find MIDI Out %s", client.MidiDevice.MidiInName)
+		return fmt.Errorf("could not find MIDI In %s: %w", client.MidiDevice.MidiInName, err)
 	}
 
-	if in == nil || out == nil {
-		return
+	var out drivers.Out
+	if client.MidiDevice.MidiOutName != "" {
+		out, err = findOutPort(client.MidiDevice.MidiOutName, client.MidiDevice.MidiPortIndex)
+		if err != nil {
+			client.log.Warn().Msgf("Could not find MIDI Out %s, running input only: %s", client.MidiDevice.MidiOutName, err)
+		}
 	}
 
 	if err := in.Open(); err != nil {
-		panic(err)
+		return fmt.Errorf("could not open MIDI In %s: %w", in, err)
 	}
+	defer in.Close()
+	client.log.Info().Msgf("Opened MIDI In %s", in)
 
-	if err := out.Open(); err != nil {
-		panic(err)
+	if out != nil {
+		if err := out.Open(); err != nil {
+			return fmt.Errorf("could not open MIDI Out %s: %w", out, err)
+		}
+		defer out.Close()
+		client.log.Info().Msgf("Opened MIDI Out %s", out)
 	}
 
-	defer in.Close()
-	defer out.Close()
-
 	onMessage := func(sysExChannel chan []byte) func(msg midi.Message, timestampMs int32) {
-		var doActions = func(rule configuration.Rule, value uint8) {
-			for _, action := range rule.Actions {
-				switch action.Type {
-				case configuration.SetVolume:
-					var minValue uint8
-					var maxValue uint8
-					if rule.MidiMessage.MinValue != 0 {
-						minValue = rule.MidiMessage.MinValue
-					} else {
-						minValue = 0
-					}
-					if rule.MidiMessage.MaxValue != 0 {
-						maxValue = rule.MidiMessage.MaxValue
-					} else {
-						maxValue = 0x7f
-					}
-					volumePercent := float32(value) / float32(maxValue-minValue)
-					if err := client.PAClient.ProcessVolumeAction(action, volumePercent); err != nil {
-						client.log.Error().Err(err)
-					}
-				case configuration.ToggleMute:
-					if value == 0 {
-						return
-					}
-					if err := client.PAClient.ProcessToggleMute(action); err != nil {
-						client.log.Error().Err(err)
-					}
-				case configuration.SetDefaultOutput:
-					if value == 0 {
-						return
-					}
-					if err := client.PAClient.SetDefaultOutput(action); err != nil {
-						client.log.Error().Err(err)
-					}
-				default:
-					client.log.Error().Msgf("Unknown action type %s in rule %+v", action.Type, rule)
-				}
-			}
-		}
 		return func(message midi.Message, timestampMs int32) {
 			client.log.Debug().Msgf("Received MIDI message (%s) from in port %v", message.String(), in)
-			switch message.Type() {
-			case midi.NoteOnMsg, midi.NoteOffMsg:
-				var channel uint8
-				var note uint8
-				var velocity uint8
-				message.GetNoteOn(&channel, &note, &velocity)
-				rules := lo.Filter(client.Rules, func(rule configuration.Rule, i int) bool {
-					return rule.MidiMessage.Type == configuration.Note &&
-						rule.MidiMessage.Channel == channel &&
-						rule.MidiMessage.Note == note
-				})
-				for _, rule := range rules {
-					doActions(rule, velocity)
+			var channel, data1, data2 uint8
+			var relative int16
+			var absolute uint16
+			var sysEx []byte
+			switch {
+			case message.GetNoteOn(&channel, &data1, &data2):
+				client.input(configuration.Note, channel, data1, data2)
+			case message.GetNoteOff(&channel, &data1, &data2):
+				client.input(configuration.Note, channel, data1, 0)
+			case message.GetControlChange(&channel, &data1, &data2):
+				client.input(configuration.ControlChange, channel, data1, data2)
+			case message.GetProgramChange(&channel, &data1):
+				client.input(configuration.ProgramChange, channel, data1, 0x7f)
+			case message.GetPitchBend(&channel, &relative, &absolute):
+				// 14-bit value reduced to 7 bits
+				client.input(configuration.PitchBend, channel, 0, uint8(absolute>>7))
+			case message.GetSysEx(&sysEx):
+				if client.sysExInput(sysEx) {
+					return
 				}
-			case midi.ControlChangeMsg:
-				var channel uint8
-				var controller uint8
-				var ccValue uint8
-				message.GetControlChange(&channel, &controller, &ccValue)
-				rules := lo.Filter(client.Rules, func(rule configuration.Rule, i int) bool {
-					return rule.MidiMessage.Type == configuration.ControlChange &&
-						rule.MidiMessage.Channel == channel &&
-						rule.MidiMessage.Controller == controller
-				})
-				for _, rule := range rules {
-					doActions(rule, ccValue)
-				}
-			case midi.ProgramChangeMsg:
-				var channel uint8
-				var program uint8
-				message.GetProgramChange(&channel, &program)
-				rules := lo.Filter(client.Rules, func(rule configuration.Rule, i int) bool {
-					return rule.MidiMessage.Type == configuration.ProgramChange &&
-						rule.MidiMessage.Channel == channel &&
-						rule.MidiMessage.Program == program
-				})
-				for _, rule := range rules {
-					doActions(rule, 0x7f)
+				select {
+				case sysExChannel <- sysEx:
+				default:
+					client.log.Debug().Msgf("Ignoring unexpected SysEx message % X", sysEx)
 				}
-			case midi.SysExMsg:
-				var bytes []byte
-				message.GetSysEx(&bytes)
-				sysExChannel <- bytes
 			}
 		}
 	}
 
-	sysExChannel := make(chan []byte)
+	sysExChannel := make(chan []byte, 1)
+	errChannel := make(chan error, 1)
 
-	if _, err = midi.ListenTo(in, onMessage(sysExChannel), midi.UseSysEx()); err != nil {
-		panic(err)
+	client.setRules(nil, nil)
+	stop, err := midi.ListenTo(in, onMessage(sysExChannel), midi.UseSysEx(), midi.HandleError(func(err error) {
+		select {
+		case errChannel <- err:
+		default:
+		}
+	}))
+	if err != nil {
+		return fmt.Errorf("could not listen to MIDI In %s: %w", in, err)
 	}
+	defer stop()
 
-	if client.MidiDevice.Type == configuration.AkaiLpd8 {
-		device := akaiLpd8.New(client.MidiDevice.Name)
-		// client.log.Info().Msgf("device %+v", device)
-		// device.OnStart(sysExChannel, out)
-		client.Rules = device.UpdateRules(client.Rules, sysExChannel, out)
-	} else if client.MidiDevice.Type == configuration.KorgNanoKontrol2 {
-		device := korgNanokontrol2.New(client.MidiDevice.Name)
-		// client.log.Info().Msgf("device %+v", device)
-		// device.OnStart(sysExChannel, out)
-		client.Rules = device.UpdateRules(client.Rules, sysExChannel, out)
+	rules := client.Rules
+	deviceDriver, ok := device.New(client.MidiDevice.Type, client.MidiDevice.Name)
+	if ok {
+		err = deviceDriver.Identify(sysExChannel, out)
+		if errors.Is(err, device.ErrNoOut) {
+			// Control paths can only be resolved by querying the device
+			deviceDriver = nil
+		} else if err != nil {
+			return fmt.Errorf("could not identify device: %w", err)
+		}
+	}
+	if deviceDriver != nil && client.startupDriver == nil {
+		client.startupDriver = deviceDriver
+	}
+	if client.MidiDevice.Program != nil && !client.programWritten {
+		// After the identification, which reads the active program restored
+		// on shutdown
+		if err := client.writeProgram(deviceDriver, sysExChannel, out, *client.MidiDevice.Program); err != nil {
+			return fmt.Errorf("could not write program: %w", err)
+		}
+		client.programWritten = true
+	}
+	if deviceDriver != nil {
+		rules = device.ResolveRules(deviceDriver, rules)
+	} else {
+		rules = lo.Filter(rules, func(rule configuration.Rule, i int) bool {
+			if rule.MidiMessage.DeviceControlPath != "" {
+				client.log.Warn().Msgf("Ignoring rule with device control path %s, device has no driver or no MIDI Out", rule.MidiMessage.DeviceControlPath)
+				return false
+			}
+			return true
+		})
+	}
+	client.setRules(deviceDriver, rules)
+
+	if feedbackDriver, ok := deviceDriver.(device.FeedbackDriver); ok && out != nil {
+		feedbackCtx, cancel := context.WithCancel(ctx)
+		feedbackDone := make(chan struct{})
+		go func() {
+			defer close(feedbackDone)
+			client.runFeedback(feedbackCtx, feedbackDriver, out)
+		}()
+		// Stop the feedback before the ports are closed
+		defer func() {
+			cancel()
+			<-feedbackDone
+		}()
 	}
 
-	select {}
+	stopWatching := func() {}
+	if watcher, ok := deviceDriver.(device.SetupWatcher); ok && out != nil {
+		watchCtx, cancel := context.WithCancel(ctx)
+		watchDone := make(chan struct{})
+		go func() {
+			defer close(watchDone)
+			client.watchSetup(watchCtx, watcher, sysExChannel, out)
+		}()
+		stopWatching = func() {
+			cancel()
+			<-watchDone
+		}
+	}
+	// Stop watching the setup before the ports are closed
+	defer stopWatching()
+
+	select {
+	case err = <-errChannel:
+		return fmt.Errorf("could not listen to MIDI In %s: %w", in, err)
+	case <-ctx.Done():
+		// No setup request while restoring the state
+		stopWatching()
+		if restorer, ok := client.startupDriver.(device.StateRestorer); ok && out != nil {
+			if err := restorer.RestoreState(sysExChannel, out); err != nil {
+				client.log.Error().Err(err).Msg("Could not restore device state")
+			}
+		}
+		return nil
+	}
 }
@@ -0,0 +1,62 @@
+package midi
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/samber/lo"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// portNameMatcher returns the matcher of a MIDI port name pattern. Patterns
+// are plain substrings, unless enclosed in slashes, e.g. "/^LPD8 MIDI [12]$/",
+// which makes them regular expressions.
+func portNameMatcher(pattern string) (func(name string) bool, error) {
+	if len(pattern) < 2 || !strings.HasPrefix(pattern, "/") || !strings.HasSuffix(pattern, "/") {
+		return func(name string) bool {
+			return strings.Contains(name, pattern)
+		}, nil
+	}
+	re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+	if err != nil {
+		return nil, fmt.Errorf("bad MIDI port name pattern %s: %w", pattern, err)
+	}
+	return re.MatchString, nil
+}
+
+// findPort returns the index-th port (starting at 0) whose name matches pattern,
+// so that identical devices can be told apart by their order.
+func findPort[T drivers.Port](ports []T, pattern string, index int) (T, error) {
+	var port T
+	match, err := portNameMatcher(pattern)
+	if err != nil {
+		return port, err
+	}
+	matchingPorts := lo.Filter(ports, func(port T, i int) bool {
+		return match(port.String())
+	})
+	if len(matchingPorts) == 0 {
+		return port, fmt.Errorf("no MIDI port matching %q", pattern)
+	}
+	if index < 0 || index >= len(matchingPorts) {
+		return port, fmt.Errorf("no MIDI port matching %q at index %d (%d matching ports)", pattern, index, len(matchingPorts))
+	}
+	return matchingPorts[index], nil
+}
+
+func findInPort(pattern string, index int) (drivers.In, error) {
+	ins, err := drivers.Ins()
+	if err != nil {
+		return nil, err
+	}
+	return findPort(ins, pattern, index)
+}
+
+func findOutPort(pattern string, index int) (drivers.Out, error) {
+	outs, err := drivers.Outs()
+	if err != nil {
+		return nil, err
+	}
+	return findPort(outs, pattern, index)
+}
@@ -0,0 +1,61 @@
+package midi
+
+import (
+	"testing"
+)
+
+// MIDI port with a name only
+type testPort string
+
+func (port testPort) Open() error     { return nil }
+func (port testPort) Close() error    { return nil }
+func (port testPort) IsOpen() bool    { return false }
+func (port testPort) Number() int     { return 0 }
+func (port testPort) String() string  { return string(port) }
+func (port testPort) Underlying() any { return nil }
+
+func TestFindPort(t *testing.T) {
+	ports := []testPort{
+		"Midi Through Port-0",
+		"LPD8 MIDI 1",
+		"Launch Control XL (1)",
+		"LPD8 MIDI 2",
+		"nanoKONTROL2 nanoKONTROL2 _ CTR",
+	}
+	tests := []struct {
+		pattern string
+		index   int
+		want    testPort
+		wantErr bool
+	}{
+		{pattern: "LPD8", want: "LPD8 MIDI 1"},
+		{pattern: "LPD8", index: 1, want: "LPD8 MIDI 2"},
+		{pattern: "LPD8", index: 2, wantErr: true},
+		{pattern: "LPD8", index: -1, wantErr: true},
+		// Regular expression characters are matched literally
+		{pattern: "Launch Control XL (1)", want: "Launch Control XL (1)"},
+		{pattern: "XL (2)", wantErr: true},
+		{pattern: "/^LPD8 MIDI [2-9]$/", want: "LPD8 MIDI 2"},
+		{pattern: "/^nano.*CTR$/", want: "nanoKONTROL2 nanoKONTROL2 _ CTR"},
+		{pattern: "/LPD8/", index: 1, want: "LPD8 MIDI 2"},
+		{pattern: "/^LPD8$/", wantErr: true},
+		{pattern: "/LPD8 (/", wantErr: true},
+		// A lone slash is a substring
+		{pattern: "/", wantErr: true},
+		{pattern: "Keystation", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := findPort(ports, test.pattern, test.index)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("findPort(%q, %d) = %q, want an error", test.pattern, test.index, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("findPort(%q, %d) failed: %s", test.pattern, test.index, err)
+		} else if got != test.want {
+			t.Errorf("findPort(%q, %d) = %q, want %q", test.pattern, test.index, got, test.want)
+		}
+	}
+}
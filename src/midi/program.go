@@ -0,0 +1,117 @@
+package midi
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/rs/zerolog/log"
+	"gitlab.com/gomidi/midi/v2/drivers"
+	"gopkg.in/yaml.v3"
+)
+
+// Programs backup file content
+type programBackup struct {
+	Programs []configuration.DeviceProgram `yaml:"programs"`
+}
+
+// writeProgram writes the configured program to the device if the device
+// one differs, and selects it, the identified driver then reading the active
+// program again
+func (client *MidiClient) writeProgram(deviceDriver device.Driver, c chan []byte, out drivers.Out, program configuration.DeviceProgram) error {
+	if out == nil {
+		client.log.Warn().Msg("Ignoring program, device has no MIDI Out")
+		return nil
+	}
+	programDriver, ok := deviceDriver.(device.ProgramDriver)
+	if !ok {
+		client.log.Warn().Msgf("Ignoring program, %s devices have no programs", client.MidiDevice.Type)
+		return nil
+	}
+	deviceProgram, err := programDriver.ReadProgram(c, out, program.Number)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(deviceProgram, program) {
+		client.log.Info().Msgf("Writing program %d", program.Number)
+		if err := programDriver.WriteProgram(c, out, program); err != nil {
+			return err
+		}
+	}
+	if err := programDriver.SelectProgram(c, out, program.Number); err != nil {
+		return err
+	}
+	if watcher, ok := deviceDriver.(device.SetupWatcher); ok {
+		if _, err := watcher.SetupChanged(c, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// asProgramDriver returns the driver of a device with programs
+func asProgramDriver(deviceDriver device.Driver, midiDevice configuration.MidiDevice) (device.ProgramDriver, error) {
+	programDriver, ok := deviceDriver.(device.ProgramDriver)
+	if !ok {
+		return nil, fmt.Errorf("%s devices have no programs", midiDevice.Type)
+	}
+	return programDriver, nil
+}
+
+// BackupPrograms saves all the programs of a device to a YAML file
+func BackupPrograms(midiDevice configuration.MidiDevice, path string) error {
+	return withDevice(midiDevice, func(deviceDriver device.Driver, c chan []byte, out drivers.Out) error {
+		programDriver, err := asProgramDriver(deviceDriver, midiDevice)
+		if err != nil {
+			return err
+		}
+		var backup programBackup
+		for number := 1; number <= programDriver.Programs(); number++ {
+			program, err := programDriver.ReadProgram(c, out, uint8(number))
+			if err != nil {
+				return err
+			}
+			backup.Programs = append(backup.Programs, program)
+		}
+		content, err := yaml.Marshal(backup)
+		if err != nil {
+			return fmt.Errorf("could not encode programs: %w", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return fmt.Errorf("could not write programs: %w", err)
+		}
+		log.Info().Msgf("Saved %d programs of %s to %s", len(backup.Programs), midiDevice.Name, path)
+		return nil
+	})
+}
+
+// RestorePrograms writes the programs of a YAML file, saved by
+// BackupPrograms, to a device
+func RestorePrograms(midiDevice configuration.MidiDevice, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read programs: %w", err)
+	}
+	var backup programBackup
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&backup); err != nil {
+		return fmt.Errorf("could not decode programs %s: %w", path, err)
+	}
+	return withDevice(midiDevice, func(deviceDriver device.Driver, c chan []byte, out drivers.Out) error {
+		programDriver, err := asProgramDriver(deviceDriver, midiDevice)
+		if err != nil {
+			return err
+		}
+		for _, program := range backup.Programs {
+			if err := programDriver.WriteProgram(c, out, program); err != nil {
+				return err
+			}
+		}
+		log.Info().Msgf("Restored %d programs of %s from %s", len(backup.Programs), midiDevice.Name, path)
+		return nil
+	})
+}
@@ -0,0 +1,142 @@
+package midi
+
+import (
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/samber/lo"
+)
+
+// Rule triggered by a control, index being the position of the control among
+// the ones matched by the rule
+type ruleMatch struct {
+	rule  *configuration.Rule
+	index int
+}
+
+// Rules by triggering control
+type ruleIndex map[controlKey][]ruleMatch
+
+// messageNumbers returns the note, controller or program numbers of a
+// message, pitch bends having none
+func messageNumbers(midiMessage configuration.MidiMessage) []uint8 {
+	switch midiMessage.Type {
+	case configuration.Note:
+		return midiMessage.Note.Values()
+	case configuration.ControlChange:
+		return midiMessage.Controller.Values()
+	case configuration.ProgramChange:
+		return midiMessage.Program.Values()
+	}
+	return []uint8{0}
+}
+
+// controlCount returns the number of controls matched by a message
+func controlCount(midiMessage configuration.MidiMessage) int {
+	return len(midiMessage.Channel.Values()) * len(messageNumbers(midiMessage))
+}
+
+// Rules with device control paths resolved, with the zone states of their
+// controls and the device driver, replaced when the device setup changes
+type resolvedRules struct {
+	// Driver of the device, nil if it has none
+	driver device.Driver
+	rules  []configuration.Rule
+	index  ruleIndex
+	// Current zone index of the rules with zones
+	zones map[zoneKey]int
+}
+
+func newRuleIndex(rules []configuration.Rule) ruleIndex {
+	index := ruleIndex{}
+	for i := range rules {
+		rule := &rules[i]
+		numbers := messageNumbers(rule.MidiMessage)
+		for c, channel := range rule.MidiMessage.Channel.Values() {
+			for n, number := range numbers {
+				key := controlKey{Type: rule.MidiMessage.Type, Channel: channel, Number: number}
+				index[key] = append(index[key], ruleMatch{rule: rule, index: c*len(numbers) + n})
+			}
+		}
+	}
+	return index
+}
+
+// setRules replaces the resolved rules and the driver which resolved them
+func (client *MidiClient) setRules(driver device.Driver, rules []configuration.Rule) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.resolvedRules = &resolvedRules{
+		driver: driver,
+		rules:  rules,
+		index:  newRuleIndex(rules),
+		zones:  map[zoneKey]int{},
+	}
+}
+
+// resolved returns the current resolved rules
+func (client *MidiClient) resolved() *resolvedRules {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.resolvedRules
+}
+
+// bankOffset returns the offset of the current bank of the device, added to
+// the index of the controls resolved from control paths
+func (client *MidiClient) bankOffset(rule *configuration.Rule) int {
+	if rule.MidiMessage.DeviceControlPath == "" {
+		return 0
+	}
+	if bankDriver, ok := client.resolved().driver.(device.BankDriver); ok {
+		return bankDriver.BankOffset()
+	}
+	return 0
+}
+
+// matchIndex returns the index of the control triggering a rule, shifted by
+// the device bank
+func (client *MidiClient) matchIndex(match ruleMatch) ruleMatch {
+	match.index += client.bankOffset(match.rule)
+	return match
+}
+
+// dispatch runs the actions of the rules triggered by a MIDI message, and
+// feeds the gesture detector if some rules match on gestures
+func (client *MidiClient) dispatch(messageType configuration.MidiMessageType, channel uint8, number uint8, value uint8) {
+	key := controlKey{Type: messageType, Channel: channel, Number: number}
+	hasGestures := false
+	resolved := client.resolved()
+	for _, match := range resolved.index[key] {
+		match := client.matchIndex(match)
+		if match.rule.MidiMessage.Gesture == configuration.NoGesture {
+			client.doActions(*match.rule, value, match.index)
+			if len(match.rule.Zones) > 0 {
+				client.updateZone(resolved.zones, match, key, value)
+			}
+		} else {
+			hasGestures = true
+		}
+	}
+	if hasGestures {
+		client.gestures.handle(key, value)
+		if messageType == configuration.ProgramChange {
+			// Program changes have no release, they are instant presses
+			client.gestures.handle(key, 0)
+		}
+	}
+}
+
+func (client *MidiClient) onGesture(key controlKey, gesture configuration.Gesture) {
+	for _, match := range client.resolved().index[key] {
+		match := client.matchIndex(match)
+		if match.rule.MidiMessage.Gesture == gesture {
+			client.log.Debug().Msgf("%s on %s %d/%d", gesture, key.Type, key.Channel, key.Number)
+			client.doActions(*match.rule, 0x7f, match.index)
+		}
+	}
+}
+
+func (client *MidiClient) hasDoublePress(key controlKey) bool {
+	return lo.SomeBy(client.resolved().index[key], func(match ruleMatch) bool {
+		return match.rule.MidiMessage.Gesture == configuration.DoublePress
+	})
+}
@@ -0,0 +1,100 @@
+package midi
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"github.com/rs/zerolog/log"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// asSceneEditor returns the driver of a device whose scene can be edited
+func asSceneEditor(deviceDriver device.Driver, midiDevice configuration.MidiDevice) (device.SceneEditor, error) {
+	sceneEditor, ok := deviceDriver.(device.SceneEditor)
+	if !ok {
+		return nil, fmt.Errorf("%s devices have no scene editor", midiDevice.Type)
+	}
+	return sceneEditor, nil
+}
+
+// DumpScene saves the current scene of a device to a YAML file
+func DumpScene(midiDevice configuration.MidiDevice, path string) error {
+	return withDevice(midiDevice, func(deviceDriver device.Driver, c chan []byte, out drivers.Out) error {
+		sceneEditor, err := asSceneEditor(deviceDriver, midiDevice)
+		if err != nil {
+			return err
+		}
+		sceneData, err := sceneEditor.ReadScene(c, out)
+		if err != nil {
+			return err
+		}
+		content, err := sceneEditor.EncodeSceneYaml(sceneData)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return fmt.Errorf("could not write scene: %w", err)
+		}
+		log.Info().Msgf("Saved scene of %s to %s", midiDevice.Name, path)
+		return nil
+	})
+}
+
+// CheckScene checks a YAML scene file of a device, without the device
+func CheckScene(midiDevice configuration.MidiDevice, path string) error {
+	deviceDriver, ok := device.New(midiDevice.Type, midiDevice.Name)
+	if !ok {
+		return fmt.Errorf("%s devices have no driver", midiDevice.Type)
+	}
+	sceneEditor, err := asSceneEditor(deviceDriver, midiDevice)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read scene: %w", err)
+	}
+	if _, err := sceneEditor.DecodeSceneYaml(content, nil); err != nil {
+		return fmt.Errorf("invalid scene %s: %w", path, err)
+	}
+	log.Info().Msgf("Scene %s is valid", path)
+	return nil
+}
+
+// UploadScene sends a YAML scene file to a device as its current scene, and
+// writes it to the device memory if save is set
+func UploadScene(midiDevice configuration.MidiDevice, path string, save bool) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read scene: %w", err)
+	}
+	return withDevice(midiDevice, func(deviceDriver device.Driver, c chan []byte, out drivers.Out) error {
+		sceneEditor, err := asSceneEditor(deviceDriver, midiDevice)
+		if err != nil {
+			return err
+		}
+		// Bytes not in the file, e.g. reserved ones, are kept
+		currentSceneData, err := sceneEditor.ReadScene(c, out)
+		if err != nil {
+			return err
+		}
+		sceneData, err := sceneEditor.DecodeSceneYaml(content, currentSceneData)
+		if err != nil {
+			return fmt.Errorf("invalid scene %s: %w", path, err)
+		}
+		if err := sceneEditor.WriteScene(c, out, sceneData); err != nil {
+			return err
+		}
+		log.Info().Msgf("Uploaded scene %s to %s", path, midiDevice.Name)
+		if !save {
+			return nil
+		}
+		if err := sceneEditor.SaveScene(c, out); err != nil {
+			return err
+		}
+		log.Info().Msgf("Saved scene to %s memory", midiDevice.Name)
+		return nil
+	})
+}
@@ -0,0 +1,68 @@
+package midi
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+
+	driver "gitlab.com/gomidi/midi/v2/drivers/portmididrv"
+)
+
+// withDevice opens the ports of a device, identifies it with its driver, then
+// runs f, e.g. for a device setup command. The device needs a MIDI Out.
+func withDevice(midiDevice configuration.MidiDevice, f func(deviceDriver device.Driver, c chan []byte, out drivers.Out) error) error {
+	deviceDriver, ok := device.New(midiDevice.Type, midiDevice.Name)
+	if !ok {
+		return fmt.Errorf("%s devices have no driver", midiDevice.Type)
+	}
+	if midiDevice.MidiOutName == "" {
+		return fmt.Errorf("device %s has no MIDI Out", midiDevice.Name)
+	}
+
+	drv, err := driver.New()
+	if err != nil {
+		return fmt.Errorf("could not initialize MIDI driver: %w", err)
+	}
+	defer drv.Close()
+
+	in, err := findInPort(midiDevice.MidiInName, midiDevice.MidiPortIndex)
+	if err != nil {
+		return fmt.Errorf("could not find MIDI In %s: %w", midiDevice.MidiInName, err)
+	}
+	out, err := findOutPort(midiDevice.MidiOutName, midiDevice.MidiPortIndex)
+	if err != nil {
+		return fmt.Errorf("could not find MIDI Out %s: %w", midiDevice.MidiOutName, err)
+	}
+	if err := in.Open(); err != nil {
+		return fmt.Errorf("could not open MIDI In %s: %w", in, err)
+	}
+	defer in.Close()
+	if err := out.Open(); err != nil {
+		return fmt.Errorf("could not open MIDI Out %s: %w", out, err)
+	}
+	defer out.Close()
+
+	sysExChannel := make(chan []byte, 1)
+	stop, err := midi.ListenTo(in, func(message midi.Message, timestampMs int32) {
+		var sysEx []byte
+		if message.GetSysEx(&sysEx) {
+			select {
+			case sysExChannel <- sysEx:
+			default:
+			}
+		}
+	}, midi.UseSysEx())
+	if err != nil {
+		return fmt.Errorf("could not listen to MIDI In %s: %w", in, err)
+	}
+	defer stop()
+
+	if err := deviceDriver.Identify(sysExChannel, out); err != nil && !errors.Is(err, device.ErrNoOut) {
+		return fmt.Errorf("could not identify device: %w", err)
+	}
+	return f(deviceDriver, sysExChannel, out)
+}
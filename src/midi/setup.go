@@ -0,0 +1,40 @@
+package midi
+
+import (
+	"context"
+	"time"
+
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// Delay between two device setup checks
+const setupCheckInterval = 2 * time.Second
+
+// watchSetup checks the device setup, e.g. its scene or program, and resolves
+// the rules again when it changed on the device, until ctx is cancelled
+func (client *MidiClient) watchSetup(ctx context.Context, watcher device.SetupWatcher, c chan []byte, out drivers.Out) {
+	ticker := time.NewTicker(setupCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		changed, err := watcher.SetupChanged(c, out)
+		if err != nil {
+			client.log.Warn().Msgf("Could not check device setup: %s", err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		client.log.Info().Msg("Device setup changed, resolving the rules again")
+		client.setRules(watcher, device.ResolveRules(watcher, client.Rules))
+		select {
+		case client.rulesChanges <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package midi
+
+import (
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+)
+
+// Zone state of a rule, per control matched by the rule
+type zoneKey struct {
+	rule    *configuration.Rule
+	control controlKey
+}
+
+// zoneContains tells whether a value is in a zone widened by margin
+func zoneContains(zone configuration.Zone, value uint8, margin uint8) bool {
+	return int(value) >= int(zone.MinValue)-int(margin) && int(value) <= int(zone.MaxValue)+int(margin)
+}
+
+// updateZone runs the actions of the zone a value enters. A value stays in
+// the current zone while it is within the rule hysteresis of it, and values
+// outside of any zone do not leave the current zone.
+func (client *MidiClient) updateZone(zones map[zoneKey]int, match ruleMatch, key controlKey, value uint8) {
+	rule := match.rule
+	current, ok := zones[zoneKey{rule: rule, control: key}]
+	if ok && zoneContains(rule.Zones[current], value, rule.Hysteresis) {
+		return
+	}
+	for i, zone := range rule.Zones {
+		if i == current && ok {
+			continue
+		}
+		if zoneContains(zone, value, 0) {
+			zones[zoneKey{rule: rule, control: key}] = i
+			client.log.Debug().Msgf("Entering zone %d-%d", zone.MinValue, zone.MaxValue)
+			client.doActions(configuration.Rule{MidiMessage: rule.MidiMessage, Actions: zone.Actions, MutePolicy: rule.MutePolicy}, 0x7f, match.index)
+			return
+		}
+	}
+}
@@ -1,12 +1,19 @@
 package pamixermidicontrol
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/DavidGamba/go-getoptions"
 	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/fluciotto/pamixermidicontrol/src/device"
+	_ "github.com/fluciotto/pamixermidicontrol/src/device/all"
+	"github.com/fluciotto/pamixermidicontrol/src/device/definition"
 	"github.com/fluciotto/pamixermidicontrol/src/midi"
 	"github.com/fluciotto/pamixermidicontrol/src/pulseaudio"
 	"github.com/rs/zerolog"
@@ -14,6 +21,9 @@ import (
 	"github.com/samber/lo"
 )
 
+// Maximum time to stop MIDI devices and duckers on shutdown
+const shutdownTimeout = 5 * time.Second
+
 var (
 	commit    string
 	version   string
@@ -23,9 +33,6 @@ var (
 func Run() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
 
-	// Create PulseAudio client
-	paClient := pulseaudio.NewPAClient()
-
 	// Parse command line
 	opt := getoptions.New()
 	opt.Self("", "Control your PulseAudio mixer with MIDI controller(s)")
@@ -34,23 +41,31 @@ func Run() {
 	opt.Bool("list", false, opt.Alias("l"), opt.Description("List MIDI ports & PulseAudio objects"))
 	opt.Bool("list-midi", false, opt.Alias("m"), opt.Description("List MIDI ports"))
 	opt.Bool("list-pulse", false, opt.Alias("p"), opt.Description("List PulseAudio objects"))
+	opt.Bool("list-controls", false, opt.Description("List device control paths"))
 	opt.Bool("version", false, opt.Alias("v"), opt.Description("Show version"))
+	opt.String("device", "", opt.ArgName("name"), opt.Description("Configured device of the program commands"))
+	opt.String("backup-programs", "", opt.ArgName("file"), opt.Description("Save the programs of the device to file"))
+	opt.String("restore-programs", "", opt.ArgName("file"), opt.Description("Write the programs of file to the device"))
+	opt.String("dump-scene", "", opt.ArgName("file"), opt.Description("Save the current scene of the device to file"))
+	opt.String("check-scene", "", opt.ArgName("file"), opt.Description("Check a scene file of the device"))
+	opt.String("upload-scene", "", opt.ArgName("file"), opt.Description("Send the scene of file to the device as its current scene"))
+	opt.Bool("save-scene", false, opt.Description("Write the uploaded scene to the device memory"))
 	opt.Parse(os.Args[1:])
 	if opt.Called("help") {
 		fmt.Fprint(os.Stderr, opt.Help())
 		os.Exit(0)
 	}
 	if opt.Called("list") {
-		midi.List()
-		paClient.List()
+		exitOnError(midi.List())
+		exitOnError(pulseaudio.List())
 		os.Exit(0)
 	}
 	if opt.Called("list-midi") {
-		midi.List()
+		exitOnError(midi.List())
 		os.Exit(0)
 	}
 	if opt.Called("list-pulse") {
-		paClient.List()
+		exitOnError(pulseaudio.List())
 		os.Exit(0)
 	}
 	if opt.Called("version") {
@@ -58,8 +73,15 @@ func Run() {
 		os.Exit(0)
 	}
 
+	// Device definitions, before the configuration using their device types
+	exitOnError(definition.Load(definition.Dirs()))
+	if opt.Called("list-controls") {
+		device.ListControlPaths()
+		os.Exit(0)
+	}
+
 	// Configuration
-	config, path, err := configuration.Load()
+	config, path, err := configuration.Load(device.Types())
 	if err != nil {
 		log.Error().Msgf("Configuration error %+v", err)
 		os.Exit(1)
@@ -67,14 +89,95 @@ func Run() {
 	log.Info().Msgf("Loaded configuration from %s", path)
 	// fmt.Printf("%+v\n", config)
 
+	// Device program and scene commands
+	commands := []string{"backup-programs", "restore-programs", "dump-scene", "check-scene", "upload-scene"}
+	if lo.SomeBy(commands, opt.Called) {
+		midiDevice, ok := lo.Find(config.MidiDevices, func(midiDevice configuration.MidiDevice) bool {
+			return midiDevice.Name == opt.Value("device")
+		})
+		if !ok {
+			log.Error().Msgf("No configured device %q, set it with --device", opt.Value("device"))
+			os.Exit(1)
+		}
+		switch {
+		case opt.Called("backup-programs"):
+			exitOnError(midi.BackupPrograms(midiDevice, opt.Value("backup-programs").(string)))
+		case opt.Called("restore-programs"):
+			exitOnError(midi.RestorePrograms(midiDevice, opt.Value("restore-programs").(string)))
+		case opt.Called("dump-scene"):
+			exitOnError(midi.DumpScene(midiDevice, opt.Value("dump-scene").(string)))
+		case opt.Called("check-scene"):
+			exitOnError(midi.CheckScene(midiDevice, opt.Value("check-scene").(string)))
+		case opt.Called("upload-scene"):
+			exitOnError(midi.UploadScene(midiDevice, opt.Value("upload-scene").(string), opt.Called("save-scene")))
+		}
+		os.Exit(0)
+	}
+
+	// Create PulseAudio client
+	paClient := pulseaudio.NewPAClient(config.PulseAudio)
+	if err := paClient.RestoreLevels(config.Groups); err != nil {
+		log.Error().Err(err).Msg("Could not restore levels")
+	}
+
+	// Stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Create dynamic strips before the MIDI clients use them
+	stripAssigner, err := pulseaudio.NewStripAssigner(paClient, config.Strips)
+	exitOnError(err)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		stripAssigner.Run(ctx)
+	}()
+
 	// Create MIDI clients
 	for _, midiDevice := range config.MidiDevices {
 		deviceRules := lo.Filter(config.Rules, func(rule configuration.Rule, i int) bool {
 			return rule.MidiMessage.DeviceName == midiDevice.Name
 		})
 		midiClient := midi.NewMidiClient(paClient, midiDevice, deviceRules)
-		go midiClient.Run()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			midiClient.Run(ctx)
+		}()
+	}
+
+	// Create duckers
+	for i, ducking := range config.Ducking {
+		ducker := pulseaudio.NewDucker(paClient, i, ducking)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ducker.Run(ctx)
+		}()
+	}
+
+	<-ctx.Done()
+	// A second signal kills the process
+	stop()
+	log.Info().Msg("Shutting down")
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		log.Error().Msgf("Did not stop within %s", shutdownTimeout)
+		os.Exit(1)
 	}
+	paClient.Close()
+}
 
-	select {}
+func exitOnError(err error) {
+	if err != nil {
+		log.Error().Msgf("%+v", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,179 @@
+package pulseaudio
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/the-jonsey/pulseaudio"
+)
+
+const (
+	// Delays between two connection attempts
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+	// Period of the connection check
+	connectionCheckPeriod = 1 * time.Second
+	// Maximum number of actions queued during an outage
+	maxQueuedActions = 100
+)
+
+// connect connects to the PulseAudio server, waiting for it to be available.
+// It returns nil if the client is closed in the meantime.
+func (client *PAClient) connect() server {
+	delay := minReconnectDelay
+	for {
+		context, err := pulseaudio.NewClient()
+		if err == nil {
+			client.log.Info().Msg("Connected to PulseAudio server")
+			return paServer{context}
+		}
+		client.log.Warn().Msgf("Could not connect to PulseAudio server, retrying in %s: %s", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-client.done:
+			return nil
+		}
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// Close stops reconnection attempts, saves the levels and closes the
+// connection to the server
+func (client *PAClient) Close() {
+	close(client.done)
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if err := client.saveLevels(); err != nil {
+		client.log.Error().Err(err).Msg("Could not save levels")
+	}
+	if client.context != nil {
+		client.context.Close()
+	}
+}
+
+// monitor subscribes to PulseAudio updates, forwards them to the subscribers,
+// and reconnects to the server when the connection is lost.
+func (client *PAClient) monitor() {
+	ticker := time.NewTicker(connectionCheckPeriod)
+	defer ticker.Stop()
+	for {
+		client.mutex.Lock()
+		context := client.context
+		client.mutex.Unlock()
+		if updates, err := context.Updates(); err == nil {
+		loop:
+			for {
+				select {
+				case <-updates:
+					client.applyUpdate()
+					client.notify()
+				case <-ticker.C:
+					if !context.Connected() {
+						break loop
+					}
+				case <-client.done:
+					return
+				}
+			}
+		} else {
+			client.log.Error().Err(err).Msg("Could not subscribe to PulseAudio updates")
+		}
+		client.log.Warn().Msg("Lost connection to PulseAudio server")
+		// Unblocks any request still waiting on the dead connection
+		client.mutex.Lock()
+		context.Close()
+		client.context = nil
+		client.mutex.Unlock()
+		newContext := client.connect()
+		if newContext == nil {
+			return
+		}
+		client.mutex.Lock()
+		client.context = newContext
+		client.replayQueuedActions()
+		client.mutex.Unlock()
+		client.applyUpdate()
+		client.notify()
+	}
+}
+
+// do runs fn with the client lock held if the server is connected. During an
+// outage, fn is queued for when the connection is back or dropped, depending
+// on the configured policy.
+func (client *PAClient) do(description string, fn func() error) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.serverConnected() {
+		err := fn()
+		if err == nil || client.serverConnected() {
+			return err
+		}
+	}
+	if client.config.OutagePolicy == configuration.QueueActions {
+		if len(client.queuedActions) >= maxQueuedActions {
+			return fmt.Errorf("could not %s: not connected to PulseAudio server and too many queued actions", description)
+		}
+		client.queuedActions = append(client.queuedActions, fn)
+		client.log.Debug().Msgf("Not connected to PulseAudio server, queued %s", description)
+		return nil
+	}
+	return fmt.Errorf("could not %s: not connected to PulseAudio server", description)
+}
+
+// connected tells whether the server is connected
+func (client *PAClient) connected() bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.serverConnected()
+}
+
+// serverConnected tells whether the server is connected. The client lock must
+// be held.
+func (client *PAClient) serverConnected() bool {
+	return client.context != nil && client.context.Connected()
+}
+
+// query runs fn with the client lock held if the server is connected, it is
+// never queued.
+func (client *PAClient) query(description string, fn func() error) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if !client.serverConnected() {
+		return fmt.Errorf("could not %s: not connected to PulseAudio server", description)
+	}
+	return fn()
+}
+
+func (client *PAClient) replayQueuedActions() {
+	if len(client.queuedActions) > 0 {
+		client.log.Info().Msgf("Replaying %d queued actions", len(client.queuedActions))
+	}
+	for _, fn := range client.queuedActions {
+		if err := fn(); err != nil {
+			client.log.Error().Err(err).Msg("Could not replay queued action")
+		}
+	}
+	client.queuedActions = nil
+}
+
+// Subscribe returns a channel notified when PulseAudio objects change or
+// when the connection to the server is re-established.
+func (client *PAClient) Subscribe() <-chan struct{} {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	updates := make(chan struct{}, 1)
+	client.subscribers = append(client.subscribers, updates)
+	return updates
+}
+
+func (client *PAClient) notify() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	for _, subscriber := range client.subscribers {
+		select {
+		case subscriber <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package pulseaudio
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Period of the ducking gain updates
+const duckingPeriod = 50 * time.Millisecond
+
+// Ducker lowers the ducking targets while the ducking trigger is active,
+// relatively to the volumes set with the MIDI controls.
+type Ducker struct {
+	log      zerolog.Logger
+	name     string
+	paClient *PAClient
+	config   configuration.Ducking
+	// Current attenuation in dB, from 0 to config.Amount
+	attenuation float64
+	active      bool
+	releaseTime time.Time
+}
+
+func NewDucker(paClient *PAClient, index int, config configuration.Ducking) *Ducker {
+	name := fmt.Sprintf("ducking%d", index)
+	return &Ducker{
+		log:      log.With().Str("module", "Ducking").Str("trigger", config.Trigger.Name).Logger(),
+		name:     name,
+		paClient: paClient,
+		config:   config,
+	}
+}
+
+func (ducker *Ducker) Run(ctx context.Context) {
+	updates := ducker.paClient.Subscribe()
+	ticker := time.NewTicker(duckingPeriod)
+	defer ticker.Stop()
+	ducker.update()
+	for {
+		select {
+		case <-updates:
+			ducker.update()
+			// New target streams may have appeared
+			if ducker.attenuation != 0 {
+				ducker.apply()
+			}
+		case <-ticker.C:
+			if ducker.step() {
+				ducker.apply()
+			}
+		case <-ctx.Done():
+			if ducker.attenuation != 0 {
+				ducker.attenuation = 0
+				ducker.apply()
+			}
+			return
+		}
+	}
+}
+
+// update checks the trigger state
+func (ducker *Ducker) update() {
+	active, err := ducker.paClient.Playing(ducker.config.Trigger, ducker.config.Detection != configuration.DetectPresent)
+	if err != nil {
+		ducker.log.Error().Err(err).Msg("Could not get trigger state")
+		return
+	}
+	if active && !ducker.active {
+		ducker.log.Info().Msg("Trigger active, ducking")
+	} else if !active && ducker.active {
+		ducker.log.Info().Msgf("Trigger inactive, releasing in %s", ducker.config.Hold)
+		ducker.releaseTime = time.Now().Add(ducker.config.Hold)
+	}
+	ducker.active = active
+}
+
+// step moves the attenuation towards its goal, returns whether it changed
+func (ducker *Ducker) step() bool {
+	amount := float64(ducker.config.Amount)
+	previous := ducker.attenuation
+	if ducker.active {
+		ducker.attenuation = moveTowards(ducker.attenuation, amount, amount, ducker.config.Attack)
+	} else if time.Now().After(ducker.releaseTime) {
+		ducker.attenuation = moveTowards(ducker.attenuation, 0, amount, ducker.config.Release)
+	}
+	return ducker.attenuation != previous
+}
+
+// moveTowards moves value towards goal by the step needed to cover amount in duration
+func moveTowards(value float64, goal float64, amount float64, duration time.Duration) float64 {
+	if duration <= 0 {
+		return goal
+	}
+	step := math.Abs(amount) * float64(duckingPeriod) / float64(duration)
+	if math.Abs(goal-value) <= step {
+		return goal
+	}
+	if goal > value {
+		return value + step
+	}
+	return value - step
+}
+
+func (ducker *Ducker) apply() {
+	gain := dBToVolumeFactor(ducker.attenuation)
+	if err := ducker.paClient.SetGain(ducker.name, ducker.config.Targets, gain); err != nil {
+		ducker.log.Error().Err(err).Msg("Could not apply ducking")
+	}
+}
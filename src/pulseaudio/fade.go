@@ -0,0 +1,150 @@
+package pulseaudio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/samber/lo"
+)
+
+// Period of the fade volume updates
+const fadePeriod = 40 * time.Millisecond
+
+// Running fade, cancelled by a new fade or volume change on its target
+type fade struct {
+	cancel context.CancelFunc
+}
+
+func targetKey(target *configuration.TypedTarget) string {
+	if target.Strip != "" {
+		return fmt.Sprintf("strip/%s/%d", target.Strip, stripSlot(target))
+	}
+	if target.Focus != "" {
+		return "focus/" + target.Focus
+	}
+	if target.Indexed {
+		return fmt.Sprintf("%s/#%d", target.Type, target.Index)
+	}
+	return string(target.Type) + "/" + target.Name
+}
+
+// curveValue maps a fade progress from 0 to 1 onto the curve
+func curveValue(curve configuration.FadeCurve, progress float64) float64 {
+	switch curve {
+	case configuration.EaseInCurve:
+		return progress * progress
+	case configuration.EaseOutCurve:
+		return 1 - (1-progress)*(1-progress)
+	case configuration.SCurve:
+		return (1 - math.Cos(math.Pi*progress)) / 2
+	}
+	return progress
+}
+
+// cancelFade stops the fade running on target, if any. The client lock must be held.
+func (client *PAClient) cancelFade(target *configuration.TypedTarget) {
+	if fade, ok := client.fades[targetKey(target)]; ok {
+		fade.cancel()
+		delete(client.fades, targetKey(target))
+	}
+}
+
+// setTargetVolume sets the fader volume of the streams matching target. The
+// client lock must be held.
+func (client *PAClient) setTargetVolume(target *configuration.TypedTarget, volume float32) error {
+	var errs []error
+	lo.ForEach(client.findStreams(target), func(stream Stream, index int) {
+		if err := client.setFaderVolume(stream, volume); err != nil {
+			errs = append(errs, fmt.Errorf("could not set %s volume: %w", stream.name, err))
+			return
+		}
+		client.log.Debug().Msgf("Set %s volume to %f", stream.name, volume)
+	})
+	return errors.Join(errs...)
+}
+
+// FadeVolume moves the volume of the streams matching target to volume over
+// duration, in the background. It cancels any fade running on target.
+func (client *PAClient) FadeVolume(target configuration.TypedTarget, volume float32, duration time.Duration, curve configuration.FadeCurve) error {
+	return client.do("fade volume", func() error {
+		return client.startFade(target, volume, duration, curve)
+	})
+}
+
+// startFade starts a fade of the streams matching target. The client lock
+// must be held.
+func (client *PAClient) startFade(target configuration.TypedTarget, volume float32, duration time.Duration, curve configuration.FadeCurve) error {
+	volume = min(max(volume, 0), configuration.MaxVolumeLimit)
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
+	streams := client.findStreams(&target)
+	if len(streams) == 0 {
+		return nil
+	}
+	startVolume := client.level(streams[0]).fader
+	client.cancelFade(&target)
+	ctx, cancel := context.WithCancel(context.Background())
+	key := targetKey(&target)
+	thisFade := &fade{cancel: cancel}
+	client.fades[key] = thisFade
+	go func() {
+		defer cancel()
+		ticker := time.NewTicker(fadePeriod)
+		defer ticker.Stop()
+		startTime := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-client.done:
+				return
+			case <-ticker.C:
+			}
+			progress := 1.0
+			if duration > 0 {
+				progress = min(float64(time.Since(startTime))/float64(duration), 1)
+			}
+			if !client.connected() {
+				// Only the end of the fade is queued or dropped during an
+				// outage, according to the outage policy
+				progress = 1
+			}
+			stepVolume := startVolume + (volume-startVolume)*float32(curveValue(curve, progress))
+			err := client.do("fade volume", func() error {
+				// Cancelled by a new fade or volume change while waiting for
+				// the lock or the connection, the end of the fade being
+				// replayed after the goroutine returned
+				if client.fades[key] != thisFade {
+					return nil
+				}
+				if progress == 1 {
+					delete(client.fades, key)
+				}
+				if err := client.refreshStreams(); err != nil {
+					return err
+				}
+				return client.setTargetVolume(&target, stepVolume)
+			})
+			if err != nil {
+				client.log.Error().Err(err).Msgf("Could not fade %s volume", target.Name)
+			}
+			if progress == 1 {
+				if err != nil {
+					// The end of the fade was dropped
+					client.mutex.Lock()
+					if client.fades[key] == thisFade {
+						delete(client.fades, key)
+					}
+					client.mutex.Unlock()
+				}
+				return
+			}
+		}
+	}()
+	return nil
+}
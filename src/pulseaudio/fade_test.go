@@ -0,0 +1,93 @@
+package pulseaudio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+)
+
+// waitFor polls condition with the client lock held until it is true
+func waitFor(t *testing.T, client *PAClient, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		client.mutex.Lock()
+		ok := condition()
+		client.mutex.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(fadePeriod / 4)
+	}
+	t.Fatalf("timed out waiting for %s", description)
+}
+
+func TestFadeVolume(t *testing.T) {
+	client, server := newTestClient(configuration.PulseAudio{})
+	defer close(client.done)
+	music := server.add(configuration.PlaybackStream, "Music", 0.2, false)
+	target := configuration.TypedTarget{Type: configuration.PlaybackStream, Name: "Music"}
+	if err := client.FadeVolume(target, 0.8, 5*fadePeriod, configuration.LinearCurve); err != nil {
+		t.Fatalf("FadeVolume failed: %s", err)
+	}
+	waitFor(t, client, "fade end", func() bool {
+		return len(client.fades) == 0
+	})
+	if volume := music.GetVolume(); volume != 0.8 {
+		t.Errorf("volume %f, want 0.8", volume)
+	}
+}
+
+func TestFadeVolumeQueuedDuringOutage(t *testing.T) {
+	client, server := newTestClient(configuration.PulseAudio{OutagePolicy: configuration.QueueActions})
+	defer close(client.done)
+	music := server.add(configuration.PlaybackStream, "Music", 0.2, false)
+	target := configuration.TypedTarget{Type: configuration.PlaybackStream, Name: "Music"}
+	if err := client.FadeVolume(target, 0.8, time.Minute, configuration.LinearCurve); err != nil {
+		t.Fatalf("FadeVolume failed: %s", err)
+	}
+	server.setConnected(false)
+	waitFor(t, client, "queued fade end", func() bool {
+		return len(client.queuedActions) > 0
+	})
+	// The fade goroutine returns after queuing the end of the fade
+	time.Sleep(4 * fadePeriod)
+	server.setConnected(true)
+	client.mutex.Lock()
+	client.replayQueuedActions()
+	fades := len(client.fades)
+	client.mutex.Unlock()
+	if volume := music.GetVolume(); volume != 0.8 {
+		t.Errorf("volume %f after replay, want 0.8", volume)
+	}
+	if fades != 0 {
+		t.Errorf("%d fades after replay, want 0", fades)
+	}
+}
+
+func TestFadeVolumeCancelledDuringOutage(t *testing.T) {
+	client, server := newTestClient(configuration.PulseAudio{OutagePolicy: configuration.QueueActions})
+	defer close(client.done)
+	music := server.add(configuration.PlaybackStream, "Music", 0.2, false)
+	target := configuration.TypedTarget{Type: configuration.PlaybackStream, Name: "Music"}
+	if err := client.FadeVolume(target, 0.8, time.Minute, configuration.LinearCurve); err != nil {
+		t.Fatalf("FadeVolume failed: %s", err)
+	}
+	server.setConnected(false)
+	waitFor(t, client, "queued fade end", func() bool {
+		return len(client.queuedActions) > 0
+	})
+	// A volume change queued after the fade end replaces it
+	action := configuration.Action{Type: configuration.SetVolume, Target: &target}
+	if err := client.ProcessVolumeAction(action, 0.5); err != nil {
+		t.Fatalf("ProcessVolumeAction failed: %s", err)
+	}
+	server.setConnected(true)
+	client.mutex.Lock()
+	client.replayQueuedActions()
+	client.mutex.Unlock()
+	if volume := music.GetVolume(); volume != 0.5 {
+		t.Errorf("volume %f after replay, want 0.5", volume)
+	}
+}
@@ -0,0 +1,72 @@
+package pulseaudio
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/samber/lo"
+)
+
+// Stream selected with focus actions, controlled by the focus targets
+type focus struct {
+	kind configuration.PulseAudioTargetType
+	name string
+}
+
+// findFocusStreams returns the streams selected by a focus target. The client
+// lock must be held.
+func (client *PAClient) findFocusStreams(target *configuration.TypedTarget) []Stream {
+	focus, ok := client.focuses[target.Focus]
+	if !ok {
+		return nil
+	}
+	return lo.Filter(client.streamsOfType(focus.kind), func(stream Stream, i int) bool {
+		return stream.name == focus.name
+	})
+}
+
+// MoveFocus selects the next stream of the action target type, or the
+// previous one if step is negative, by creation order
+func (client *PAClient) MoveFocus(action configuration.Action, step int) error {
+	err := client.do("move focus", func() error {
+		return client.moveFocus(action, step)
+	})
+	// The feedback shows the focused streams
+	client.notify()
+	return err
+}
+
+func (client *PAClient) moveFocus(action configuration.Action, step int) error {
+	target, ok := action.Target.(*configuration.TypedTarget)
+	if !ok {
+		return nil
+	}
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
+	streams := slices.Clone(client.streamsOfType(target.Type))
+	slices.SortFunc(streams, func(a Stream, b Stream) int {
+		return cmp.Compare(a.index(), b.index())
+	})
+	names := lo.Uniq(lo.FilterMap(streams, func(stream Stream, i int) (string, bool) {
+		return stream.name, stream.name != ""
+	}))
+	if len(names) == 0 {
+		client.log.Info().Msgf("Focus %s has no %s to select", target.Focus, target.Type)
+		return nil
+	}
+	current, ok := client.focuses[target.Focus]
+	position := -1
+	if ok && current.kind == target.Type {
+		position = slices.Index(names, current.name)
+	}
+	if position < 0 {
+		// Nothing selected yet, start from either end
+		position = lo.Ternary(step > 0, -1, len(names))
+	}
+	position = ((position+step)%len(names) + len(names)) % len(names)
+	client.focuses[target.Focus] = &focus{kind: target.Type, name: names[position]}
+	client.log.Info().Msgf("Focus %s selected %s", target.Focus, names[position])
+	return nil
+}
@@ -0,0 +1,259 @@
+package pulseaudio
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/samber/lo"
+)
+
+// Volumes changing less than this are not applied, so that re-applying
+// gains on PulseAudio updates does not trigger new updates forever
+const volumeTolerance = 0.005
+
+// Volume set by the user and gains applied on top of it by automations
+type streamLevel struct {
+	fader float32
+	gains map[string]float32
+	// Restored from the levels file, applied once when the stream appears
+	restored bool
+}
+
+func (level *streamLevel) volume() float32 {
+	volume := level.fader
+	for _, gain := range level.gains {
+		volume *= gain
+	}
+	return min(volume, configuration.MaxVolumeLimit)
+}
+
+// level returns the level of a stream, initialising its fader value from the
+// current volume if it was never set from a MIDI control
+func (client *PAClient) level(stream Stream) *streamLevel {
+	if level, ok := client.levels[stream.key()]; ok {
+		return level
+	}
+	level := &streamLevel{fader: stream.volume(), gains: map[string]float32{}}
+	client.levels[stream.key()] = level
+	return level
+}
+
+func (client *PAClient) applyLevel(stream Stream, level *streamLevel) error {
+	volume := level.volume()
+	if math.Abs(float64(volume-stream.volume())) < volumeTolerance {
+		return nil
+	}
+	return stream.setVolume(volume)
+}
+
+// setFaderVolume sets the volume chosen by the user, keeping the gains
+func (client *PAClient) setFaderVolume(stream Stream, volume float32) error {
+	level := client.level(stream)
+	level.fader = volume
+	level.restored = false
+	client.levelsChanged = true
+	return client.applyLevel(stream, level)
+}
+
+// dBToVolumeFactor converts a gain in dB to a PulseAudio volume factor.
+// PulseAudio volumes are cubic, an amplitude factor is a volume factor cubed.
+func dBToVolumeFactor(dB float64) float32 {
+	return float32(math.Pow(10, dB/60))
+}
+
+// SetGain applies a gain named source on top of the fader volume of the
+// streams matching targets. A gain of 1 removes it.
+func (client *PAClient) SetGain(source string, targets []configuration.TypedTarget, gain float32) error {
+	return client.query("set gain", func() error {
+		if err := client.refreshStreams(); err != nil {
+			return err
+		}
+		return client.setGain(source, targets, gain)
+	})
+}
+
+// setGain applies a named gain on the streams matching targets. The client
+// lock must be held.
+func (client *PAClient) setGain(source string, targets []configuration.TypedTarget, gain float32) error {
+	var errs []error
+	for _, target := range targets {
+		lo.ForEach(client.findStreams(&target), func(stream Stream, i int) {
+			level := client.level(stream)
+			if len(level.gains) == 0 && !level.restored {
+				// The volume may have been changed by another application
+				level.fader = stream.volume()
+			}
+			if gain == 1 {
+				delete(level.gains, source)
+			} else {
+				level.gains[source] = gain
+			}
+			if err := client.applyLevel(stream, level); err != nil {
+				errs = append(errs, fmt.Errorf("could not set %s volume: %w", stream.name, err))
+			}
+		})
+	}
+	return errors.Join(errs...)
+}
+
+// groupGainSource names the gain applied by a group master
+func groupGainSource(group *configuration.Group) string {
+	return groupGainPrefix + group.Name
+}
+
+// ProcessGroupVolume scales the group targets by volume, relatively to their
+// own fader volumes
+func (client *PAClient) ProcessGroupVolume(action configuration.Action, volume float32) error {
+	group, ok := action.Target.(*configuration.Group)
+	if !ok {
+		return nil
+	}
+	return client.do("set group volume", func() error {
+		client.groupGains[group.Name] = min(max(volume, 0), configuration.MaxVolumeLimit)
+		client.levelsChanged = true
+		if err := client.refreshStreams(); err != nil {
+			return err
+		}
+		return client.applyGroupGains()
+	})
+}
+
+// applyGroupGains applies the group master volumes to the group streams,
+// including the ones which appeared since the master last moved. The client
+// lock must be held.
+func (client *PAClient) applyGroupGains() error {
+	var errs []error
+	for _, group := range client.groups {
+		gain, ok := client.groupGains[group.Name]
+		if !ok {
+			continue
+		}
+		source := groupGainSource(&group)
+		for _, target := range group.Targets {
+			lo.ForEach(client.findStreams(&target), func(stream Stream, i int) {
+				level := client.level(stream)
+				if current, ok := level.gains[source]; ok && current == gain {
+					return
+				}
+				level.gains[source] = gain
+				if err := client.applyLevel(stream, level); err != nil {
+					errs = append(errs, fmt.Errorf("could not set %s volume: %w", stream.name, err))
+				}
+			})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyRestoredLevels applies the restored fader volumes to the streams
+// which appeared since. The client lock must be held.
+func (client *PAClient) applyRestoredLevels() error {
+	var errs []error
+	var applied []*streamLevel
+	for _, streams := range [][]Stream{client.outputs, client.inputs, client.playbackStreams, client.recordStreams} {
+		lo.ForEach(streams, func(stream Stream, i int) {
+			level, ok := client.levels[stream.key()]
+			if !ok || !level.restored {
+				return
+			}
+			applied = append(applied, level)
+			if err := client.applyLevel(stream, level); err != nil {
+				errs = append(errs, fmt.Errorf("could not set %s volume: %w", stream.name, err))
+				return
+			}
+			client.log.Debug().Msgf("Restored %s volume to %f", stream.name, level.fader)
+		})
+	}
+	// All the streams of a key get the level
+	for _, level := range applied {
+		level.restored = false
+	}
+	return errors.Join(errs...)
+}
+
+// hasRestoredLevels tells whether restored fader volumes were not applied
+// yet. The client lock must be held.
+func (client *PAClient) hasRestoredLevels() bool {
+	return lo.SomeBy(lo.Values(client.levels), func(level *streamLevel) bool {
+		return level.restored
+	})
+}
+
+// applyUpdate applies the restored levels, group gains and solos to the
+// streams on PulseAudio updates, e.g. to the new streams
+func (client *PAClient) applyUpdate() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.applyAutomations()
+}
+
+// applyAutomations applies the restored levels, group gains and solos to the
+// streams, logging the errors. The client lock must be held.
+func (client *PAClient) applyAutomations() {
+	if len(client.groupGains) == 0 && len(client.solos) == 0 && !client.hasRestoredLevels() || !client.serverConnected() {
+		return
+	}
+	if err := client.refreshStreams(); err != nil {
+		client.log.Error().Err(err).Msg("Could not refresh streams")
+		return
+	}
+	if err := client.applyRestoredLevels(); err != nil {
+		client.log.Error().Err(err).Msg("Could not apply restored volumes")
+	}
+	if err := client.applyGroupGains(); err != nil {
+		client.log.Error().Err(err).Msg("Could not apply group volumes")
+	}
+	if err := client.applySolos(); err != nil {
+		client.log.Error().Err(err).Msg("Could not apply solos")
+	}
+}
+
+// Playing tells whether any stream matching target is present, and if
+// playing is set, uncorked or running.
+func (client *PAClient) Playing(target configuration.TypedTarget, playing bool) (bool, error) {
+	var result bool
+	err := client.query("get stream state", func() error {
+		if err := client.refreshStreams(); err != nil {
+			return err
+		}
+		result = lo.SomeBy(client.findStreams(&target), func(stream Stream) bool {
+			return !playing || stream.playing()
+		})
+		return nil
+	})
+	return result, err
+}
+
+// State of a stream shown on the controls
+type StreamState struct {
+	Name   string
+	Volume float32
+	Muted  bool
+}
+
+// TargetStates returns the state of the first stream matching each target,
+// with its fader volume, nil if there is none. The streams are refreshed once
+// for all the targets.
+func (client *PAClient) TargetStates(targets []configuration.TypedTarget) ([]*StreamState, error) {
+	states := make([]*StreamState, len(targets))
+	err := client.query("get target states", func() error {
+		if err := client.refreshStreams(); err != nil {
+			return err
+		}
+		for i := range targets {
+			streams := client.findStreams(&targets[i])
+			if len(streams) == 0 {
+				continue
+			}
+			states[i] = &StreamState{
+				Name:   streams[0].name,
+				Volume: client.level(streams[0]).fader,
+				Muted:  streams[0].muted(),
+			}
+		}
+		return nil
+	})
+	return states, err
+}
@@ -1,7 +1,11 @@
 package pulseaudio
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
 	"slices"
+	"sync"
 
 	"github.com/fluciotto/pamixermidicontrol/src/configuration"
 	"github.com/rs/zerolog"
@@ -10,38 +14,74 @@ import (
 	"github.com/the-jonsey/pulseaudio"
 )
 
-type Stream struct {
-	name     string
-	fullName string
-	paStream interface{}
-}
-
 type PAClient struct {
 	log             zerolog.Logger
-	context         *pulseaudio.Client
+	config          configuration.PulseAudio
+	mutex           sync.Mutex
+	context         server
+	done            chan struct{}
+	queuedActions   []func() error
+	subscribers     []chan struct{}
 	outputs         []Stream
 	playbackStreams []Stream
 	inputs          []Stream
 	recordStreams   []Stream
+	levels          map[string]*streamLevel
+	fades           map[string]*fade
+	strips          map[string]*strip
+	focuses         map[string]*focus
+	// Solo states by solo group name, empty for all playback streams
+	solos  map[string]*soloState
+	groups []configuration.Group
+	// Group master volumes by group name
+	groupGains map[string]float32
+	// Levels file, levels are not saved if empty
+	levelsPath    string
+	levelsChanged bool
 }
 
-func NewPAClient() *PAClient {
-	context, err := pulseaudio.NewClient()
-	if err != nil {
-		panic(err)
+// NewPAClient connects to the PulseAudio server, waiting for it if needed,
+// and keeps the connection alive.
+func NewPAClient(config configuration.PulseAudio) *PAClient {
+	client := newPAClient(config)
+	client.context = client.connect()
+	if client.context != nil {
+		go client.monitor()
 	}
-	client := &PAClient{
+	return client
+}
+
+func newPAClient(config configuration.PulseAudio) *PAClient {
+	return &PAClient{
 		log:             log.With().Str("module", "PulseAudio").Logger(),
-		context:         context,
+		config:          config,
+		done:            make(chan struct{}),
 		outputs:         []Stream{},
 		playbackStreams: []Stream{},
 		inputs:          []Stream{},
 		recordStreams:   []Stream{},
+		levels:          map[string]*streamLevel{},
+		fades:           map[string]*fade{},
+		strips:          map[string]*strip{},
+		focuses:         map[string]*focus{},
+		solos:           map[string]*soloState{},
+		groupGains:      map[string]float32{},
 	}
-	return client
 }
 
-func (client *PAClient) List() {
+// List lists the PulseAudio objects, with a single connection attempt
+func List() error {
+	context, err := pulseaudio.NewClient()
+	if err != nil {
+		return fmt.Errorf("could not connect to PulseAudio server: %w", err)
+	}
+	defer context.Close()
+	client := newPAClient(configuration.PulseAudio{})
+	client.context = paServer{context}
+	return client.list()
+}
+
+func (client *PAClient) list() error {
 	if server, err := client.context.ServerInfo(); err == nil {
 		client.log.Info().Msgf("PulseAudio server\t\tHostname=%s", server.Hostname)
 		client.log.Info().Msgf("\t\t\t\tUser=%s", server.User)
@@ -52,7 +92,9 @@ func (client *PAClient) List() {
 		client.log.Info().Msgf("\t\t\t\tDefault input=%s", server.DefaultSource)
 		client.log.Info().Msgf("\t\t\t\tDefault output=%s", server.DefaultSink)
 	}
-	client.refreshStreams()
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
 	// List sinks
 	lo.ForEach(client.outputs, func(stream Stream, i int) {
 		client.log.Info().Msgf("Found output device:\t%s", stream.name)
@@ -69,203 +111,253 @@ func (client *PAClient) List() {
 	lo.ForEach(client.recordStreams, func(stream Stream, i int) {
 		client.log.Info().Msgf("Found record stream:\t%s", stream.name)
 	})
+	return nil
 }
 
 func (client *PAClient) refreshStreams() error {
-	// Sinks
-	sinks, err := client.context.Sinks()
+	outputs, err := client.context.streams(configuration.OutputDevice)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("could not list outputs: %w", err)
 	}
-	client.outputs = lo.Map(sinks, func(sink pulseaudio.Sink, i int) Stream {
-		return Stream{
-			name:     sink.Description,
-			fullName: sink.Name,
-			paStream: sink,
-		}
-	})
-	// Sources
-	sources, err := client.context.Sources()
+	inputs, err := client.context.streams(configuration.InputDevice)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("could not list inputs: %w", err)
 	}
-	client.inputs = lo.Map(sources, func(source pulseaudio.Source, i int) Stream {
-		return Stream{
-			name:     source.Description,
-			fullName: source.Name,
-			paStream: source,
-		}
-	})
-	// Sinks inputs
-	sinksInputs, err := client.context.SinkInputs()
+	playbackStreams, err := client.context.streams(configuration.PlaybackStream)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("could not list playback streams: %w", err)
 	}
-	client.playbackStreams = lo.Map(sinksInputs, func(sinkInput pulseaudio.SinkInput, i int) Stream {
-		var name string
-		name = sinkInput.PropList["application.name"]
-		if len(name) < 1 {
-			name = sinkInput.PropList["media.name"]
-		}
-		return Stream{
-			name:     name,
-			fullName: sinkInput.PropList["module-stream-restore.id"],
-			paStream: sinkInput,
-		}
-	})
-	// Sources outputs
-	sourcesOutputs, err := client.context.SourceOutputs()
+	recordStreams, err := client.context.streams(configuration.RecordStream)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("could not list record streams: %w", err)
 	}
-	client.recordStreams = lo.Map(sourcesOutputs, func(sourceOutput pulseaudio.SourceOutput, i int) Stream {
-		var name string
-		name = sourceOutput.PropList["application.name"]
-		if len(name) < 1 {
-			name = sourceOutput.PropList["media.name"]
-		}
-		return Stream{
-			name:     name,
-			fullName: sourceOutput.PropList["module-stream-restore.id"],
-			paStream: sourceOutput,
-		}
-	})
+	client.outputs = outputs
+	client.inputs = inputs
+	client.playbackStreams = playbackStreams
+	client.recordStreams = recordStreams
+	client.assignStrips()
 	return nil
 }
 
-func (client *PAClient) ProcessVolumeAction(action configuration.Action, volumePercent float32) error {
+// streamsOfType returns the known streams of a target type
+func (client *PAClient) streamsOfType(targetType configuration.PulseAudioTargetType) []Stream {
+	switch targetType {
+	case configuration.OutputDevice:
+		return client.outputs
+	case configuration.InputDevice:
+		return client.inputs
+	case configuration.PlaybackStream:
+		return client.playbackStreams
+	case configuration.RecordStream:
+		return client.recordStreams
+	}
+	return nil
+}
+
+// findIndexedStream returns the stream of a type created in position index
+func (client *PAClient) findIndexedStream(targetType configuration.PulseAudioTargetType, index int) []Stream {
+	streams := slices.Clone(client.streamsOfType(targetType))
+	if index >= len(streams) {
+		return nil
+	}
+	slices.SortFunc(streams, func(a Stream, b Stream) int {
+		return cmp.Compare(a.index(), b.index())
+	})
+	return streams[index : index+1]
+}
+
+// findStreams returns the streams matching a typed target
+func (client *PAClient) findStreams(target *configuration.TypedTarget) []Stream {
+	if target.Strip != "" {
+		return client.findStripStreams(target)
+	}
+	if target.Focus != "" {
+		return client.findFocusStreams(target)
+	}
+	if target.Indexed {
+		return client.findIndexedStream(target.Type, target.Index)
+	}
 	var streams []Stream
-	client.refreshStreams()
-	switch target := action.Target.(type) {
-	case *configuration.TypedTarget:
-		if target.Type == configuration.OutputDevice {
-			if target.Name == "Default" {
-				if defaultSink, err := client.context.GetDefaultSink(); err == nil {
-					streams = slices.Concat(streams, lo.Filter(client.outputs, func(stream Stream, i int) bool {
-						return stream.fullName == defaultSink.Name
-					}))
-				}
-			} else {
-				streams = slices.Concat(streams, lo.Filter(client.outputs, func(stream Stream, i int) bool {
-					return stream.name == target.Name
-				}))
-			}
-		} else if target.Type == configuration.InputDevice {
-			if target.Name == "Default" {
-				if defaultSource, err := client.context.GetDefaultSource(); err == nil {
-					streams = slices.Concat(streams, lo.Filter(client.inputs, func(stream Stream, i int) bool {
-						return stream.fullName == defaultSource.Name
-					}))
-				}
-			} else {
-				streams = slices.Concat(streams, lo.Filter(client.inputs, func(stream Stream, i int) bool {
-					return stream.name == target.Name
-				}))
+	if target.Type == configuration.OutputDevice {
+		if target.Name == "Default" {
+			if defaultSink, err := client.context.GetDefaultSink(); err == nil {
+				streams = lo.Filter(client.outputs, func(stream Stream, i int) bool {
+					return stream.fullName == defaultSink.Name
+				})
 			}
-		} else if target.Type == configuration.PlaybackStream {
-			streams = slices.Concat(streams, lo.Filter(client.playbackStreams, func(stream Stream, i int) bool {
+		} else {
+			streams = lo.Filter(client.outputs, func(stream Stream, i int) bool {
 				return stream.name == target.Name
-			}))
-		} else if target.Type == configuration.RecordStream {
-			streams = slices.Concat(streams, lo.Filter(client.recordStreams, func(stream Stream, i int) bool {
+			})
+		}
+	} else if target.Type == configuration.InputDevice {
+		if target.Name == "Default" {
+			if defaultSource, err := client.context.GetDefaultSource(); err == nil {
+				streams = lo.Filter(client.inputs, func(stream Stream, i int) bool {
+					return stream.fullName == defaultSource.Name
+				})
+			}
+		} else {
+			streams = lo.Filter(client.inputs, func(stream Stream, i int) bool {
 				return stream.name == target.Name
-			}))
+			})
 		}
+	} else if target.Type == configuration.PlaybackStream {
+		streams = lo.Filter(client.playbackStreams, func(stream Stream, i int) bool {
+			return stream.name == target.Name
+		})
+	} else if target.Type == configuration.RecordStream {
+		streams = lo.Filter(client.recordStreams, func(stream Stream, i int) bool {
+			return stream.name == target.Name
+		})
+	}
+	return streams
+}
+
+// findActionStreams returns the streams matching an action typed target
+func (client *PAClient) findActionStreams(action configuration.Action) []Stream {
+	switch target := action.Target.(type) {
+	case *configuration.TypedTarget:
+		return client.findStreams(target)
 	case *configuration.Target:
 	default:
 	}
-	lo.ForEach(streams, func(stream Stream, index int) {
-		switch st := stream.paStream.(type) {
-		case pulseaudio.Sink:
-			st.SetVolume(volumePercent)
-			client.log.Debug().Msgf("Set %s volume to %f", stream.name, volumePercent)
-		case pulseaudio.SinkInput:
-			st.SetVolume(volumePercent)
-			client.log.Debug().Msgf("Set %s volume to %f", stream.name, volumePercent)
-		case pulseaudio.Source:
-			st.SetVolume(volumePercent)
-			client.log.Debug().Msgf("Set %s volume to %f", stream.name, volumePercent)
-		case pulseaudio.SourceOutput:
-			st.SetVolume(volumePercent)
-			client.log.Debug().Msgf("Set %s volume to %f", stream.name, volumePercent)
-		}
-	})
 	return nil
 }
 
-func (client *PAClient) ProcessToggleMute(action configuration.Action) error {
-	var streams []Stream
-	client.refreshStreams()
+func (client *PAClient) ProcessVolumeAction(action configuration.Action, volumePercent float32) error {
+	return client.do("set volume", func() error {
+		return client.processVolumeAction(action, volumePercent)
+	})
+}
+
+func (client *PAClient) processVolumeAction(action configuration.Action, volumePercent float32) error {
+	if target, ok := action.Target.(*configuration.TypedTarget); ok && action.Smoothing > 0 {
+		return client.startFade(*target, volumePercent, action.Smoothing, configuration.LinearCurve)
+	}
+	volumePercent = min(max(volumePercent, 0), configuration.MaxVolumeLimit)
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
 	switch target := action.Target.(type) {
 	case *configuration.TypedTarget:
-		if target.Type == configuration.OutputDevice {
-			if target.Name == "Default" {
-				if defaultSink, err := client.context.GetDefaultSink(); err == nil {
-					streams = slices.Concat(streams, lo.Filter(client.outputs, func(stream Stream, i int) bool {
-						return stream.fullName == defaultSink.Name
-					}))
-				}
-			} else {
-				streams = slices.Concat(streams, lo.Filter(client.outputs, func(stream Stream, i int) bool {
-					return stream.name == target.Name
-				}))
-			}
-		} else if target.Type == configuration.InputDevice {
-			if target.Name == "Default" {
-				if defaultSource, err := client.context.GetDefaultSource(); err == nil {
-					streams = slices.Concat(streams, lo.Filter(client.inputs, func(stream Stream, i int) bool {
-						return stream.fullName == defaultSource.Name
-					}))
-				}
-			} else {
-				streams = slices.Concat(streams, lo.Filter(client.inputs, func(stream Stream, i int) bool {
-					return stream.name == target.Name
-				}))
-			}
-		} else if target.Type == configuration.PlaybackStream {
-			streams = slices.Concat(streams, lo.Filter(client.playbackStreams, func(stream Stream, i int) bool {
-				return stream.name == target.Name
-			}))
-		} else if target.Type == configuration.RecordStream {
-			streams = slices.Concat(streams, lo.Filter(client.recordStreams, func(stream Stream, i int) bool {
-				return stream.name == target.Name
-			}))
+		client.cancelFade(target)
+		return client.setTargetVolume(target, volumePercent)
+	case *configuration.Target:
+	default:
+	}
+	return nil
+}
+
+// StepVolume moves the volume of the action target by step within the action
+// volume range, for relative controls
+func (client *PAClient) StepVolume(action configuration.Action, step float32) error {
+	return client.do("step volume", func() error {
+		if err := client.refreshStreams(); err != nil {
+			return err
 		}
+		target, ok := action.Target.(*configuration.TypedTarget)
+		if !ok {
+			return nil
+		}
+		client.cancelFade(target)
+		minVolume, maxVolume := action.VolumeRange()
+		maxVolume = min(maxVolume, configuration.MaxVolumeLimit)
+		var errs []error
+		lo.ForEach(client.findStreams(target), func(stream Stream, index int) {
+			volume := min(max(client.level(stream).fader+step, minVolume), maxVolume)
+			if err := client.setFaderVolume(stream, volume); err != nil {
+				errs = append(errs, fmt.Errorf("could not set %s volume: %w", stream.name, err))
+				return
+			}
+			client.log.Debug().Msgf("Set %s volume to %f", stream.name, volume)
+		})
+		return errors.Join(errs...)
+	})
+}
+
+func (client *PAClient) ProcessFadeVolume(action configuration.Action) error {
+	switch target := action.Target.(type) {
+	case *configuration.TypedTarget:
+		return client.FadeVolume(*target, action.Volume, action.Duration, action.Curve)
 	case *configuration.Target:
 	default:
 	}
+	return nil
+}
+
+// ProcessToggleMute toggles the mute of the action targets, together
+// according to policy
+func (client *PAClient) ProcessToggleMute(actions []configuration.Action, policy configuration.MutePolicy) error {
+	return client.do("toggle mute", func() error {
+		return client.processToggleMute(actions, policy)
+	})
+}
+
+func (client *PAClient) processToggleMute(actions []configuration.Action, policy configuration.MutePolicy) error {
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
+	var streams []Stream
+	for _, action := range actions {
+		streams = append(streams, client.findActionStreams(action)...)
+	}
+	if len(streams) == 0 {
+		return nil
+	}
+	var mute bool
+	switch policy {
+	case configuration.UnmuteIfAnyMuted:
+		mute = !lo.SomeBy(streams, Stream.muted)
+	case configuration.FollowFirst:
+		mute = !streams[0].muted()
+	default:
+		mute = !lo.EveryBy(streams, Stream.muted)
+	}
+	var errs []error
 	lo.ForEach(streams, func(stream Stream, index int) {
-		switch st := stream.paStream.(type) {
-		case pulseaudio.Sink:
-			st.ToggleMute()
-			client.log.Debug().Msgf("Toggled mute on %s", stream.name)
-		case pulseaudio.SinkInput:
-			st.ToggleMute()
-			client.log.Debug().Msgf("Toggled mute on %s", stream.name)
-		case pulseaudio.Source:
-			st.ToggleMute()
-			client.log.Debug().Msgf("Toggled mute on %s", stream.name)
-		case pulseaudio.SourceOutput:
-			st.ToggleMute()
-			client.log.Debug().Msgf("Toggled mute on %s", stream.name)
+		streamMute := mute
+		if policy == configuration.ToggleIndependently {
+			streamMute = !stream.muted()
+		}
+		if err := stream.setMute(streamMute); err != nil {
+			errs = append(errs, fmt.Errorf("could not toggle mute on %s: %w", stream.name, err))
+			return
 		}
+		client.releaseSoloMute(stream)
+		client.log.Debug().Msgf("Set mute on %s to %t", stream.name, streamMute)
 	})
-	return nil
+	return errors.Join(errs...)
 }
 
 func (client *PAClient) SetDefaultOutput(action configuration.Action) error {
-	client.refreshStreams()
+	return client.do("set default output", func() error {
+		return client.setDefaultOutput(action)
+	})
+}
+
+func (client *PAClient) setDefaultOutput(action configuration.Action) error {
+	if err := client.refreshStreams(); err != nil {
+		return err
+	}
+	var errs []error
 	switch target := action.Target.(type) {
 	case *configuration.Target:
-		lo.ForEach(
-			lo.Filter(client.outputs, func(stream Stream, i int) bool {
-				return stream.name == target.Name
-			}), func(stream Stream, i int) {
-				client.context.SetDefaultSink(stream.fullName)
-				client.log.Debug().Msgf("Set default output to %s", stream.name)
-			})
+		outputs := lo.Filter(client.outputs, func(stream Stream, i int) bool {
+			return stream.name == target.Name
+		})
+		if target.Indexed {
+			outputs = client.findIndexedStream(configuration.OutputDevice, target.Index)
+		}
+		lo.ForEach(outputs, func(stream Stream, i int) {
+			if err := client.context.SetDefaultSink(stream.fullName); err != nil {
+				errs = append(errs, fmt.Errorf("could not set default output to %s: %w", stream.name, err))
+				return
+			}
+			client.log.Debug().Msgf("Set default output to %s", stream.name)
+		})
 	case *configuration.TypedTarget:
 	default:
 	}
-	return nil
+	return errors.Join(errs...)
 }
@@ -0,0 +1,124 @@
+package pulseaudio
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Prefix of the gain sources set by group masters
+	groupGainPrefix = "group/"
+	// Period of the levels file updates
+	levelsSavePeriod = 2 * time.Second
+)
+
+// Levels file content
+type savedLevels struct {
+	// Fader volumes by stream key
+	Faders map[string]float32 `yaml:"faders"`
+	// Master volumes by group name
+	Groups map[string]float32 `yaml:"groups"`
+}
+
+func levelsPath() (string, error) {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateDir = filepath.Join(homeDir, ".local", "state")
+	}
+	return filepath.Join(stateDir, "pamixermidicontrol", "levels.yaml"), nil
+}
+
+// RestoreLevels loads the fader volumes and group master volumes saved by a
+// previous run, and saves them when they change from now on. The fader
+// volumes are applied to the streams present, and to the other ones when
+// they appear.
+func (client *PAClient) RestoreLevels(groups []configuration.Group) error {
+	path, err := levelsPath()
+	if err != nil {
+		return fmt.Errorf("could not find levels file: %w", err)
+	}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.groups = groups
+	client.levelsPath = path
+	go client.saveLevelsPeriodically()
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read levels: %w", err)
+	}
+	var saved savedLevels
+	if err := yaml.Unmarshal(content, &saved); err != nil {
+		return fmt.Errorf("could not read levels: %w", err)
+	}
+	for key, fader := range saved.Faders {
+		client.levels[key] = &streamLevel{fader: fader, gains: map[string]float32{}, restored: true}
+	}
+	// Groups no longer configured are dropped
+	client.groupGains = lo.PickBy(saved.Groups, func(name string, gain float32) bool {
+		return lo.ContainsBy(groups, func(group configuration.Group) bool {
+			return group.Name == name
+		})
+	})
+	client.log.Info().Msgf("Restored %d levels from %s", len(saved.Faders), path)
+	client.applyAutomations()
+	return nil
+}
+
+// saveLevels writes the fader volumes and group master volumes if they
+// changed. The client lock must be held.
+func (client *PAClient) saveLevels() error {
+	if client.levelsPath == "" || !client.levelsChanged {
+		return nil
+	}
+	saved := savedLevels{
+		Faders: lo.MapValues(client.levels, func(level *streamLevel, key string) float32 {
+			return level.fader
+		}),
+		Groups: client.groupGains,
+	}
+	content, err := yaml.Marshal(saved)
+	if err != nil {
+		return fmt.Errorf("could not save levels: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(client.levelsPath), 0o755); err != nil {
+		return fmt.Errorf("could not save levels: %w", err)
+	}
+	if err := os.WriteFile(client.levelsPath, content, 0o644); err != nil {
+		return fmt.Errorf("could not save levels: %w", err)
+	}
+	client.levelsChanged = false
+	return nil
+}
+
+func (client *PAClient) saveLevelsPeriodically() {
+	ticker := time.NewTicker(levelsSavePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			client.mutex.Lock()
+			err := client.saveLevels()
+			client.mutex.Unlock()
+			if err != nil {
+				client.log.Error().Err(err).Msg("Could not save levels")
+			}
+		case <-client.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package pulseaudio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+)
+
+func TestRestoreLevels(t *testing.T) {
+	stateDir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateDir)
+	content := []byte("faders:\n  OutputDevice/Speakers: 0.4\n  PlaybackStream/Music: 0.3\n")
+	if err := os.MkdirAll(filepath.Join(stateDir, "pamixermidicontrol"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stateDir, "pamixermidicontrol", "levels.yaml"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	client, server := newTestClient(configuration.PulseAudio{})
+	defer close(client.done)
+	speakers := server.add(configuration.OutputDevice, "Speakers", 0.9, false)
+	if err := client.RestoreLevels(nil); err != nil {
+		t.Fatalf("RestoreLevels failed: %s", err)
+	}
+	if volume := speakers.GetVolume(); volume != 0.4 {
+		t.Errorf("present stream volume %f, want 0.4", volume)
+	}
+	// A stream outside any group appears
+	music := server.add(configuration.PlaybackStream, "Music", 0.9, false)
+	client.applyUpdate()
+	if volume := music.GetVolume(); volume != 0.3 {
+		t.Errorf("new stream volume %f, want 0.3", volume)
+	}
+	// Restored once, then left to the other applications
+	music.SetVolume(0.6)
+	client.applyUpdate()
+	if volume := music.GetVolume(); volume != 0.6 {
+		t.Errorf("stream volume %f after another application changed it, want 0.6", volume)
+	}
+}
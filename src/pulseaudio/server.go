@@ -0,0 +1,80 @@
+package pulseaudio
+
+import (
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/samber/lo"
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// Connection to the PulseAudio server
+type server interface {
+	Connected() bool
+	Close()
+	Updates() (<-chan struct{}, error)
+	ServerInfo() (*pulseaudio.Server, error)
+	// streams returns the output devices, input devices, playback streams or
+	// record streams
+	streams(kind configuration.PulseAudioTargetType) ([]Stream, error)
+	GetDefaultSink() (pulseaudio.Sink, error)
+	GetDefaultSource() (pulseaudio.Source, error)
+	SetDefaultSink(name string) error
+}
+
+// Server connected with the PulseAudio native protocol
+type paServer struct {
+	*pulseaudio.Client
+}
+
+// streamName returns the application name of a stream, or its media name
+func streamName(propList map[string]string) string {
+	if name := propList["application.name"]; len(name) > 0 {
+		return name
+	}
+	return propList["media.name"]
+}
+
+func (server paServer) streams(kind configuration.PulseAudioTargetType) ([]Stream, error) {
+	switch kind {
+	case configuration.OutputDevice:
+		sinks, err := server.Sinks()
+		return lo.Map(sinks, func(sink pulseaudio.Sink, i int) Stream {
+			return Stream{
+				name:     sink.Description,
+				fullName: sink.Name,
+				kind:     kind,
+				paStream: sink,
+			}
+		}), err
+	case configuration.InputDevice:
+		sources, err := server.Sources()
+		return lo.Map(sources, func(source pulseaudio.Source, i int) Stream {
+			return Stream{
+				name:     source.Description,
+				fullName: source.Name,
+				kind:     kind,
+				paStream: source,
+			}
+		}), err
+	case configuration.PlaybackStream:
+		sinksInputs, err := server.SinkInputs()
+		return lo.Map(sinksInputs, func(sinkInput pulseaudio.SinkInput, i int) Stream {
+			return Stream{
+				name:     streamName(sinkInput.PropList),
+				fullName: sinkInput.PropList["module-stream-restore.id"],
+				kind:     kind,
+				paStream: sinkInput,
+			}
+		}), err
+	case configuration.RecordStream:
+		sourcesOutputs, err := server.SourceOutputs()
+		return lo.Map(sourcesOutputs, func(sourceOutput pulseaudio.SourceOutput, i int) Stream {
+			return Stream{
+				name:     streamName(sourceOutput.PropList),
+				fullName: sourceOutput.PropList["module-stream-restore.id"],
+				kind:     kind,
+				paStream: sourceOutput,
+			}
+		}), err
+	}
+	return nil, nil
+}
@@ -0,0 +1,124 @@
+package pulseaudio
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// PulseAudio object whose volume and mute are kept in memory
+type fakeObject struct {
+	mutex  *sync.Mutex
+	volume float32
+	muted  bool
+}
+
+func (object *fakeObject) GetVolume() float32 {
+	object.mutex.Lock()
+	defer object.mutex.Unlock()
+	return object.volume
+}
+
+func (object *fakeObject) SetVolume(volume float32) error {
+	object.mutex.Lock()
+	defer object.mutex.Unlock()
+	object.volume = volume
+	return nil
+}
+
+func (object *fakeObject) IsMute() bool {
+	object.mutex.Lock()
+	defer object.mutex.Unlock()
+	return object.muted
+}
+
+func (object *fakeObject) SetMute(mute bool) error {
+	object.mutex.Lock()
+	defer object.mutex.Unlock()
+	object.muted = mute
+	return nil
+}
+
+// Server whose streams are kept in memory, and which can be disconnected
+type fakeServer struct {
+	mutex     sync.Mutex
+	connected bool
+	objects   map[configuration.PulseAudioTargetType][]Stream
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		connected: true,
+		objects:   map[configuration.PulseAudioTargetType][]Stream{},
+	}
+}
+
+// add adds a stream and returns its object
+func (server *fakeServer) add(kind configuration.PulseAudioTargetType, name string, volume float32, muted bool) *fakeObject {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	object := &fakeObject{mutex: &server.mutex, volume: volume, muted: muted}
+	server.objects[kind] = append(server.objects[kind], Stream{
+		name:     name,
+		fullName: name,
+		kind:     kind,
+		paStream: object,
+	})
+	return object
+}
+
+func (server *fakeServer) setConnected(connected bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.connected = connected
+}
+
+func (server *fakeServer) Connected() bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.connected
+}
+
+func (server *fakeServer) Close() {
+	server.setConnected(false)
+}
+
+func (server *fakeServer) Updates() (<-chan struct{}, error) {
+	return make(chan struct{}), nil
+}
+
+func (server *fakeServer) ServerInfo() (*pulseaudio.Server, error) {
+	return &pulseaudio.Server{}, nil
+}
+
+func (server *fakeServer) streams(kind configuration.PulseAudioTargetType) ([]Stream, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if !server.connected {
+		return nil, errors.New("not connected")
+	}
+	return slices.Clone(server.objects[kind]), nil
+}
+
+func (server *fakeServer) GetDefaultSink() (pulseaudio.Sink, error) {
+	return pulseaudio.Sink{}, errors.New("no default output")
+}
+
+func (server *fakeServer) GetDefaultSource() (pulseaudio.Source, error) {
+	return pulseaudio.Source{}, errors.New("no default input")
+}
+
+func (server *fakeServer) SetDefaultSink(name string) error {
+	return nil
+}
+
+// newTestClient returns a client connected to a fake server
+func newTestClient(config configuration.PulseAudio) (*PAClient, *fakeServer) {
+	server := newFakeServer()
+	client := newPAClient(config)
+	client.context = server
+	return client, server
+}
@@ -0,0 +1,144 @@
+package pulseaudio
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/samber/lo"
+)
+
+// Solo state of a solo group, or of all playback streams
+type soloState struct {
+	group *configuration.Group
+	// Soloed targets by target key
+	soloed map[string]configuration.TypedTarget
+	// Streams muted by the solos, unmuted when they are released
+	muted map[string]bool
+	// Streams already handled for the current solos, so that streams muted or
+	// unmuted by the user in between are left alone
+	handled map[string]bool
+}
+
+func soloScope(group *configuration.Group) string {
+	if group == nil {
+		return ""
+	}
+	return group.Name
+}
+
+// soloMembers returns the streams of the solo group. The client lock must be
+// held.
+func (client *PAClient) soloMembers(state *soloState) []Stream {
+	if state.group == nil {
+		return client.playbackStreams
+	}
+	var streams []Stream
+	for _, target := range state.group.Targets {
+		streams = append(streams, client.findStreams(&target)...)
+	}
+	return streams
+}
+
+// ProcessSolo toggles the solo of the action target within its solo group
+func (client *PAClient) ProcessSolo(action configuration.Action) error {
+	target, ok := action.Target.(*configuration.TypedTarget)
+	if !ok {
+		return nil
+	}
+	return client.do("solo", func() error {
+		if err := client.refreshStreams(); err != nil {
+			return err
+		}
+		scope := soloScope(action.SoloGroup)
+		state, ok := client.solos[scope]
+		if !ok {
+			state = &soloState{
+				group:   action.SoloGroup,
+				soloed:  map[string]configuration.TypedTarget{},
+				muted:   map[string]bool{},
+				handled: map[string]bool{},
+			}
+			client.solos[scope] = state
+		}
+		key := targetKey(target)
+		if _, ok := state.soloed[key]; ok {
+			delete(state.soloed, key)
+			client.log.Info().Msgf("Released solo on %s", target.Name)
+		} else {
+			state.soloed[key] = *target
+			client.log.Info().Msgf("Soloed %s", target.Name)
+		}
+		state.handled = map[string]bool{}
+		return client.applySolos()
+	})
+}
+
+// applySolos mutes the streams of the solo groups which are not soloed, and
+// unmutes the ones it muted when they are soloed or the solos are released.
+// The client lock must be held.
+func (client *PAClient) applySolos() error {
+	var errs []error
+	setMute := func(stream Stream, mute bool) {
+		if err := stream.setMute(mute); err != nil {
+			errs = append(errs, fmt.Errorf("could not set mute on %s: %w", stream.name, err))
+			return
+		}
+		client.log.Debug().Msgf("Set mute on %s to %t for solo", stream.name, mute)
+	}
+	for _, state := range client.solos {
+		members := client.soloMembers(state)
+		// Streams unmuted by the user since the solos muted them are handed
+		// back to the user, solo releases no longer unmute them if they are
+		// muted again
+		lo.ForEach(members, func(stream Stream, i int) {
+			if state.muted[stream.key()] && !stream.muted() {
+				delete(state.muted, stream.key())
+			}
+		})
+		if len(state.soloed) == 0 {
+			lo.ForEach(members, func(stream Stream, i int) {
+				if state.muted[stream.key()] {
+					setMute(stream, false)
+				}
+			})
+			state.muted = map[string]bool{}
+			state.handled = map[string]bool{}
+			continue
+		}
+		soloed := map[string]bool{}
+		for _, target := range state.soloed {
+			lo.ForEach(client.findStreams(&target), func(stream Stream, i int) {
+				soloed[stream.key()] = true
+			})
+		}
+		lo.ForEach(members, func(stream Stream, i int) {
+			key := stream.key()
+			if soloed[key] {
+				if state.muted[key] {
+					setMute(stream, false)
+					delete(state.muted, key)
+				}
+				state.handled[key] = true
+				return
+			}
+			if state.handled[key] {
+				return
+			}
+			state.handled[key] = true
+			if !stream.muted() {
+				setMute(stream, true)
+				state.muted[key] = true
+			}
+		})
+	}
+	return errors.Join(errs...)
+}
+
+// releaseSoloMute hands a stream mute back to the user, solo releases no
+// longer unmute it. The client lock must be held.
+func (client *PAClient) releaseSoloMute(stream Stream) {
+	for _, state := range client.solos {
+		delete(state.muted, stream.key())
+	}
+}
@@ -0,0 +1,144 @@
+package pulseaudio
+
+import (
+	"testing"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+)
+
+func playbackTarget(name string) *configuration.TypedTarget {
+	return &configuration.TypedTarget{Type: configuration.PlaybackStream, Name: name}
+}
+
+func soloAction(name string, group *configuration.Group) configuration.Action {
+	return configuration.Action{Type: configuration.Solo, Target: playbackTarget(name), SoloGroup: group}
+}
+
+func muteActions(names ...string) []configuration.Action {
+	var actions []configuration.Action
+	for _, name := range names {
+		actions = append(actions, configuration.Action{Type: configuration.ToggleMute, Target: playbackTarget(name)})
+	}
+	return actions
+}
+
+// checkMutes checks the mutes of the streams by name
+func checkMutes(t *testing.T, step string, objects map[string]*fakeObject, want map[string]bool) {
+	t.Helper()
+	for name, muted := range want {
+		if got := objects[name].IsMute(); got != muted {
+			t.Errorf("%s: %s muted %t, want %t", step, name, got, muted)
+		}
+	}
+}
+
+func TestSoloRestoresMutes(t *testing.T) {
+	client, server := newTestClient(configuration.PulseAudio{})
+	defer close(client.done)
+	objects := map[string]*fakeObject{
+		"Music": server.add(configuration.PlaybackStream, "Music", 1, false),
+		"Game":  server.add(configuration.PlaybackStream, "Game", 1, false),
+		"Chat":  server.add(configuration.PlaybackStream, "Chat", 1, true),
+	}
+	if err := client.ProcessSolo(soloAction("Music", nil)); err != nil {
+		t.Fatal(err)
+	}
+	checkMutes(t, "solo", objects, map[string]bool{"Music": false, "Game": true, "Chat": true})
+	if err := client.ProcessSolo(soloAction("Game", nil)); err != nil {
+		t.Fatal(err)
+	}
+	checkMutes(t, "second solo", objects, map[string]bool{"Music": false, "Game": false, "Chat": true})
+	if err := client.ProcessSolo(soloAction("Music", nil)); err != nil {
+		t.Fatal(err)
+	}
+	checkMutes(t, "first solo release", objects, map[string]bool{"Music": true, "Game": false, "Chat": true})
+	if err := client.ProcessSolo(soloAction("Game", nil)); err != nil {
+		t.Fatal(err)
+	}
+	// Chat was muted before the solos
+	checkMutes(t, "release", objects, map[string]bool{"Music": false, "Game": false, "Chat": true})
+}
+
+func TestSoloGroup(t *testing.T) {
+	client, server := newTestClient(configuration.PulseAudio{})
+	defer close(client.done)
+	objects := map[string]*fakeObject{
+		"Music": server.add(configuration.PlaybackStream, "Music", 1, false),
+		"Game":  server.add(configuration.PlaybackStream, "Game", 1, false),
+		"Chat":  server.add(configuration.PlaybackStream, "Chat", 1, false),
+	}
+	group := &configuration.Group{Name: "Media", Targets: []configuration.TypedTarget{*playbackTarget("Music"), *playbackTarget("Game")}}
+	if err := client.ProcessSolo(soloAction("Music", group)); err != nil {
+		t.Fatal(err)
+	}
+	// Chat is not in the solo group
+	checkMutes(t, "solo", objects, map[string]bool{"Music": false, "Game": true, "Chat": false})
+	if err := client.ProcessSolo(soloAction("Music", group)); err != nil {
+		t.Fatal(err)
+	}
+	checkMutes(t, "release", objects, map[string]bool{"Music": false, "Game": false, "Chat": false})
+}
+
+func TestSoloMuteHandedBack(t *testing.T) {
+	client, server := newTestClient(configuration.PulseAudio{})
+	defer close(client.done)
+	objects := map[string]*fakeObject{
+		"Music": server.add(configuration.PlaybackStream, "Music", 1, false),
+		"Game":  server.add(configuration.PlaybackStream, "Game", 1, false),
+		"Chat":  server.add(configuration.PlaybackStream, "Chat", 1, false),
+	}
+	if err := client.ProcessSolo(soloAction("Music", nil)); err != nil {
+		t.Fatal(err)
+	}
+	// Unmuted then muted again with ToggleMute during the solo
+	for range 2 {
+		if err := client.ProcessToggleMute(muteActions("Game"), configuration.MuteIfAnyUnmuted); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Unmuted then muted again by another application
+	objects["Chat"].SetMute(false)
+	client.applyUpdate()
+	objects["Chat"].SetMute(true)
+	client.applyUpdate()
+	if err := client.ProcessSolo(soloAction("Music", nil)); err != nil {
+		t.Fatal(err)
+	}
+	checkMutes(t, "release", objects, map[string]bool{"Music": false, "Game": true, "Chat": true})
+}
+
+func TestToggleMutePolicies(t *testing.T) {
+	tests := []struct {
+		policy configuration.MutePolicy
+		// Mutes of A, B and C before and after the toggle
+		before [3]bool
+		want   [3]bool
+	}{
+		{policy: configuration.MuteIfAnyUnmuted, before: [3]bool{true, false, true}, want: [3]bool{true, true, true}},
+		{policy: configuration.MuteIfAnyUnmuted, before: [3]bool{true, true, true}, want: [3]bool{false, false, false}},
+		// The default policy
+		{policy: "", before: [3]bool{false, true, false}, want: [3]bool{true, true, true}},
+		{policy: configuration.UnmuteIfAnyMuted, before: [3]bool{true, false, false}, want: [3]bool{false, false, false}},
+		{policy: configuration.UnmuteIfAnyMuted, before: [3]bool{false, false, false}, want: [3]bool{true, true, true}},
+		{policy: configuration.FollowFirst, before: [3]bool{false, true, false}, want: [3]bool{true, true, true}},
+		{policy: configuration.FollowFirst, before: [3]bool{true, false, true}, want: [3]bool{false, false, false}},
+		{policy: configuration.ToggleIndependently, before: [3]bool{true, false, true}, want: [3]bool{false, true, false}},
+	}
+	names := []string{"A", "B", "C"}
+	for _, test := range tests {
+		client, server := newTestClient(configuration.PulseAudio{})
+		objects := map[string]*fakeObject{}
+		for i, name := range names {
+			objects[name] = server.add(configuration.PlaybackStream, name, 1, test.before[i])
+		}
+		if err := client.ProcessToggleMute(muteActions(names...), test.policy); err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range names {
+			if got := objects[name].IsMute(); got != test.want[i] {
+				t.Errorf("%s %v: %s muted %t, want %t", test.policy, test.before, name, got, test.want[i])
+			}
+		}
+		close(client.done)
+	}
+}
@@ -0,0 +1,85 @@
+package pulseaudio
+
+import (
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/the-jonsey/pulseaudio"
+)
+
+// Output or input device, playback or record stream
+type Stream struct {
+	name     string
+	fullName string
+	kind     configuration.PulseAudioTargetType
+	paStream interface{}
+}
+
+// key identifies a stream across refreshes and reconnections
+func (stream Stream) key() string {
+	return string(stream.kind) + "/" + stream.name
+}
+
+// index returns the PulseAudio object index, increasing with creation order
+func (stream Stream) index() uint32 {
+	switch st := stream.paStream.(type) {
+	case pulseaudio.Sink:
+		return st.Index
+	case pulseaudio.SinkInput:
+		return st.Index
+	case pulseaudio.Source:
+		return st.Index
+	case pulseaudio.SourceOutput:
+		return st.Index
+	}
+	return 0
+}
+
+// Volume and mute of a PulseAudio object
+type paObject interface {
+	GetVolume() float32
+	SetVolume(volume float32) error
+	IsMute() bool
+	SetMute(mute bool) error
+}
+
+func (stream Stream) volume() float32 {
+	if object, ok := stream.paStream.(paObject); ok {
+		return object.GetVolume()
+	}
+	return 0
+}
+
+func (stream Stream) setVolume(volume float32) error {
+	if object, ok := stream.paStream.(paObject); ok {
+		return object.SetVolume(volume)
+	}
+	return nil
+}
+
+func (stream Stream) muted() bool {
+	if object, ok := stream.paStream.(paObject); ok {
+		return object.IsMute()
+	}
+	return false
+}
+
+func (stream Stream) setMute(mute bool) error {
+	if object, ok := stream.paStream.(paObject); ok {
+		return object.SetMute(mute)
+	}
+	return nil
+}
+
+// playing tells whether a stream is uncorked, or a device is running
+func (stream Stream) playing() bool {
+	switch st := stream.paStream.(type) {
+	case pulseaudio.Sink:
+		return st.SinkState == 0
+	case pulseaudio.SinkInput:
+		return !st.Corked
+	case pulseaudio.Source:
+		return st.SinkState == 0
+	case pulseaudio.SourceOutput:
+		return !st.Corked
+	}
+	return false
+}
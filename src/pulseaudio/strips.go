@@ -0,0 +1,181 @@
+package pulseaudio
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/fluciotto/pamixermidicontrol/src/configuration"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+)
+
+// Dynamic strip state
+type strip struct {
+	config  configuration.Strip
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	// Stream name bound to each slot, empty if free
+	slots []string
+	// Last slot of the streams, to give returning streams their slot back
+	previous map[string]int
+	// Streams without free slot, logged once
+	unassigned map[string]bool
+}
+
+func compileFilters(patterns []string) ([]*regexp.Regexp, error) {
+	var filters []*regexp.Regexp
+	for _, pattern := range patterns {
+		filter, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad filter %s: %w", pattern, err)
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+func matchesAny(filters []*regexp.Regexp, name string) bool {
+	return lo.SomeBy(filters, func(filter *regexp.Regexp) bool {
+		return filter.MatchString(name)
+	})
+}
+
+func (strip *strip) accepts(name string) bool {
+	if len(strip.include) > 0 && !matchesAny(strip.include, name) {
+		return false
+	}
+	return !matchesAny(strip.exclude, name)
+}
+
+// stripSlot returns the slot selected by a strip target
+func stripSlot(target *configuration.TypedTarget) int {
+	if target.Slot > 0 {
+		return target.Slot - 1
+	}
+	return target.Index
+}
+
+// findStripStreams returns the streams bound to the slot selected by a strip
+// target. The client lock must be held.
+func (client *PAClient) findStripStreams(target *configuration.TypedTarget) []Stream {
+	strip, ok := client.strips[target.Strip]
+	if !ok {
+		return nil
+	}
+	slot := stripSlot(target)
+	if slot >= len(strip.slots) || strip.slots[slot] == "" {
+		return nil
+	}
+	return lo.Filter(client.streamsOfType(strip.config.Type), func(stream Stream, i int) bool {
+		return stream.name == strip.slots[slot]
+	})
+}
+
+// assignStrips releases the slots of the streams which disappeared and binds
+// the new streams to free slots. The client lock must be held.
+func (client *PAClient) assignStrips() {
+	for _, strip := range client.strips {
+		streams := slices.Clone(client.streamsOfType(strip.config.Type))
+		// New streams get slots in creation order
+		slices.SortFunc(streams, func(a Stream, b Stream) int {
+			return cmp.Compare(a.index(), b.index())
+		})
+		names := lo.Uniq(lo.FilterMap(streams, func(stream Stream, i int) (string, bool) {
+			return stream.name, stream.name != "" && strip.accepts(stream.name)
+		}))
+		for slot, name := range strip.slots {
+			if name != "" && !slices.Contains(names, name) {
+				client.log.Info().Msgf("Strip %s slot %d released by %s", strip.config.Name, slot+1, name)
+				strip.slots[slot] = ""
+			}
+		}
+		for name := range strip.unassigned {
+			if !slices.Contains(names, name) {
+				delete(strip.unassigned, name)
+			}
+		}
+		for _, name := range names {
+			if slices.Contains(strip.slots, name) {
+				continue
+			}
+			slot, ok := strip.previous[name]
+			if !ok || strip.slots[slot] != "" {
+				slot = slices.Index(strip.slots, "")
+			}
+			if slot < 0 {
+				if !strip.unassigned[name] {
+					client.log.Warn().Msgf("Strip %s has no free slot for %s", strip.config.Name, name)
+					strip.unassigned[name] = true
+				}
+				continue
+			}
+			delete(strip.unassigned, name)
+			strip.slots[slot] = name
+			strip.previous[name] = slot
+			client.log.Info().Msgf("Strip %s slot %d bound to %s", strip.config.Name, slot+1, name)
+		}
+	}
+}
+
+// StripAssigner keeps the dynamic strips bound to the streams as they come
+// and go.
+type StripAssigner struct {
+	log      zerolog.Logger
+	paClient *PAClient
+}
+
+func NewStripAssigner(paClient *PAClient, configs []configuration.Strip) (*StripAssigner, error) {
+	strips := map[string]*strip{}
+	for _, config := range configs {
+		if config.Type == "" {
+			config.Type = configuration.PlaybackStream
+		}
+		include, err := compileFilters(config.Include)
+		if err != nil {
+			return nil, fmt.Errorf("strip %s: %w", config.Name, err)
+		}
+		exclude, err := compileFilters(config.Exclude)
+		if err != nil {
+			return nil, fmt.Errorf("strip %s: %w", config.Name, err)
+		}
+		strips[config.Name] = &strip{
+			config:     config,
+			include:    include,
+			exclude:    exclude,
+			slots:      make([]string, config.Slots),
+			previous:   map[string]int{},
+			unassigned: map[string]bool{},
+		}
+	}
+	paClient.mutex.Lock()
+	paClient.strips = strips
+	paClient.mutex.Unlock()
+	return &StripAssigner{
+		log:      log.With().Str("module", "Strips").Logger(),
+		paClient: paClient,
+	}, nil
+}
+
+func (assigner *StripAssigner) Run(ctx context.Context) {
+	updates := assigner.paClient.Subscribe()
+	assigner.assign()
+	for {
+		select {
+		case <-updates:
+			assigner.assign()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (assigner *StripAssigner) assign() {
+	// Refreshing the streams assigns the strips
+	if err := assigner.paClient.query("assign strips", assigner.paClient.refreshStreams); err != nil {
+		assigner.log.Error().Err(err).Msg("Could not assign strips")
+	}
+}